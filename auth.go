@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultJWTIssuer and defaultJWTAudience are used for the iss and aud
+// claims unless JWT_ISSUER or JWT_AUDIENCE is set.
+const (
+	defaultJWTIssuer   = "chirpy"
+	defaultJWTAudience = "chirpy"
+)
+
+// tokenTypeAccess is the token_type claim of access tokens. Refresh tokens
+// are opaque and looked up in the database, so they are never JWTs, but the
+// claim keeps any other JWT signed with the same secret from being accepted
+// as an access token.
+const tokenTypeAccess = "access"
+
+type chirpyClaims struct {
+	TokenType string `json:"token_type"`
+	jwt.RegisteredClaims
+}
+
+func hashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func checkPasswordHash(password, hash string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+func makeJWT(userID, secret, issuer, audience string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, chirpyClaims{
+		TokenType: tokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID,
+		},
+	})
+	return token.SignedString([]byte(secret))
+}
+
+// validateJWT checks tokenString's signature, expiry, issuer, audience and
+// token type, so tokens minted by other services sharing the secret are
+// rejected, and returns its subject.
+func validateJWT(tokenString, secret, issuer, audience string) (string, error) {
+	claims := chirpyClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(secret), nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if claims.TokenType != tokenTypeAccess {
+		return "", errors.New("token is not an access token")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// makeRandomToken returns 32 random bytes hex-encoded, for opaque tokens
+// such as refresh and email verification tokens.
+func makeRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of token, for tokens that are stored
+// hashed so a database leak doesn't expose usable values.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func getBearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("authorization header is missing")
+	}
+
+	token, found := strings.CutPrefix(authHeader, "Bearer ")
+	token = strings.TrimSpace(token)
+	if !found || token == "" {
+		return "", errors.New("malformed authorization header")
+	}
+	return token, nil
+}
+
+func getAPIKey(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("authorization header is missing")
+	}
+
+	key, found := strings.CutPrefix(authHeader, "ApiKey ")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return "", errors.New("malformed authorization header")
+	}
+	return key, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testJWTSecret = "test-secret"
+
+func TestValidateJWTIssuerAndAudience(t *testing.T) {
+	tests := []struct {
+		name     string
+		issuer   string
+		audience string
+		wantErr  bool
+	}{
+		{"matching", defaultJWTIssuer, defaultJWTAudience, false},
+		{"wrong issuer", "other-service", defaultJWTAudience, true},
+		{"wrong audience", defaultJWTIssuer, "other-service", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := makeJWT("user-id", testJWTSecret, tt.issuer, tt.audience, time.Minute)
+			if err != nil {
+				t.Fatalf("makeJWT: %v", err)
+			}
+
+			subject, err := validateJWT(token, testJWTSecret, defaultJWTIssuer, defaultJWTAudience)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateJWT error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && subject != "user-id" {
+				t.Errorf("subject = %q, want user-id", subject)
+			}
+		})
+	}
+}
+
+// signTestJWT signs a token like makeJWT's but with the given token_type.
+func signTestJWT(t *testing.T, subject, tokenType string) string {
+	t.Helper()
+	now := time.Now().UTC()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, chirpyClaims{
+		TokenType: tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    defaultJWTIssuer,
+			Audience:  jwt.ClaimStrings{defaultJWTAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			Subject:   subject,
+		},
+	}).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func TestValidateJWTRequiresAccessType(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenType string
+		wantErr   bool
+	}{
+		{"access", tokenTypeAccess, false},
+		{"refresh", "refresh", true},
+		{"missing", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signTestJWT(t, uuid.NewString(), tt.tokenType)
+			_, err := validateJWT(token, testJWTSecret, defaultJWTIssuer, defaultJWTAudience)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateJWT error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRejectsRefreshTypedJWT(t *testing.T) {
+	cfg := &apiConfig{jwtSecret: testJWTSecret, jwtIssuer: defaultJWTIssuer, jwtAudience: defaultJWTAudience}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestJWT(t, uuid.NewString(), "refresh"))
+	w := httptest.NewRecorder()
+
+	if _, ok := cfg.authenticate(w, r); ok {
+		t.Fatal("authenticate accepted a refresh-typed JWT")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRefreshHandlerRejectsAccessJWT(t *testing.T) {
+	const refreshToken = "opaque-refresh-token"
+	userID := uuid.New()
+
+	// Only the opaque token is on record, as only opaque tokens are ever
+	// issued as refresh tokens
+	db := newStubDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if len(args) > 0 && args[0].Value == refreshToken {
+			return []string{"user_id"}, [][]driver.Value{{userID.String()}}, nil
+		}
+		return []string{"user_id"}, nil, nil
+	})
+	cfg := &apiConfig{
+		queryTimeout: time.Second,
+		jwtSecret:    testJWTSecret,
+		jwtIssuer:    defaultJWTIssuer,
+		jwtAudience:  defaultJWTAudience,
+	}
+	handler := cfg.refreshHandler(database.New(db))
+
+	accessToken, err := makeJWT(userID.String(), testJWTSecret, defaultJWTIssuer, defaultJWTAudience, time.Minute)
+	if err != nil {
+		t.Fatalf("makeJWT: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"refresh token", refreshToken, http.StatusOK},
+		{"access JWT", accessToken, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
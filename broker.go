@@ -0,0 +1,68 @@
+package main
+
+import "sync"
+
+// subscriberBuffer is how many chirps can queue for a subscriber before
+// further chirps are dropped for it.
+const subscriberBuffer = 16
+
+// chirpBroker fans newly created chirps out to in-process subscribers such
+// as open stream connections.
+type chirpBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan Chirp]struct{}
+	closed      bool
+}
+
+func newChirpBroker() *chirpBroker {
+	return &chirpBroker{subscribers: make(map[chan Chirp]struct{})}
+}
+
+// subscribe registers a new subscriber. The returned function unregisters it
+// and must be called once the subscriber is done. The channel is closed when
+// the broker is, so subscribers should stop once it is drained.
+func (b *chirpBroker) subscribe() (<-chan Chirp, func()) {
+	ch := make(chan Chirp, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subscribers[ch] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// publish sends chirp to every subscriber without blocking. A subscriber
+// whose buffer is full misses the chirp rather than holding up the request
+// that created it.
+func (b *chirpBroker) publish(chirp Chirp) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- chirp:
+		default:
+		}
+	}
+}
+
+// close closes every subscriber's channel so open streams end. It's called
+// on shutdown, which otherwise waits for them until it times out.
+func (b *chirpBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+	_ "github.com/lib/pq"
+)
+
+// openTestDB connects to the Postgres at DB_URL and migrates it, skipping
+// the test when DB_URL isn't set. Tests write real rows, so point it at a
+// scratch database.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		t.Skip("DB_URL not set; skipping database test")
+	}
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := runMigrations(context.Background(), db, "up"); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// sleepyDB sleeps in Postgres before each single-row query, simulating a
+// query slow enough to outlast a short timeout.
+type sleepyDB struct {
+	database.DBTX
+}
+
+func (s sleepyDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	s.DBTX.ExecContext(ctx, "SELECT pg_sleep(1)")
+	return s.DBTX.QueryRowContext(ctx, query, args...)
+}
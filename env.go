@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envInt returns the integer value of the environment variable key, or def
+// if it is unset. It exits if the value is not an integer.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		logFatal(key+" must be an integer", "value", v)
+	}
+	return n
+}
+
+// envBool returns the boolean value (e.g. "true", "1", "false") of the
+// environment variable key, or def if it is unset. It exits if the value
+// does not parse.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logFatal(key+" must be true or false", "value", v)
+	}
+	return b
+}
+
+// envDuration returns the duration value (e.g. "30s", "5m") of the
+// environment variable key, or def if it is unset. It exits if the value
+// does not parse.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logFatal(key+" must be a duration such as 30s or 5m", "value", v)
+	}
+	return d
+}
+
+// envSecret returns the value of the environment variable key, unless
+// key_FILE is set, in which case it returns the contents of that file with
+// surrounding whitespace trimmed. This suits secrets mounted as files, as in
+// Kubernetes. It exits if the file can't be read.
+func envSecret(key string) string {
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return os.Getenv(key)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		logFatal("Failed to read "+key+"_FILE", "path", path, "error", err)
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,31 @@
+package main
+
+// Error codes sent alongside the message in every error response. Clients
+// branch on these rather than on the wording of the message, so once
+// released a code must keep its meaning.
+const (
+	errCodeInternal             = "internal_error"
+	errCodeTimeout              = "timeout"
+	errCodeMaintenance          = "maintenance"
+	errCodeUnavailable          = "unavailable"
+	errCodeRateLimited          = "rate_limited"
+	errCodeNotFound             = "not_found"
+	errCodeMethodNotAllowed     = "method_not_allowed"
+	errCodeUnauthorized         = "unauthorized"
+	errCodeInvalidCredentials   = "invalid_credentials"
+	errCodeForbidden            = "forbidden"
+	errCodeEmailNotVerified     = "email_not_verified"
+	errCodeUnsupportedMediaType = "unsupported_media_type"
+	errCodeBodyTooLarge         = "body_too_large"
+	errCodeMalformedBody        = "malformed_body"
+	errCodeInvalidRequest       = "invalid_request"
+	errCodeInvalidParameter     = "invalid_parameter"
+	errCodeValidationFailed     = "validation_failed"
+	errCodeInvalidToken         = "invalid_token"
+	errCodeChirpTooLong         = "chirp_too_long"
+	errCodeChirpLimitReached    = "chirp_limit_reached"
+	errCodePinLimitReached      = "pin_limit_reached"
+	errCodeParentNotFound       = "parent_not_found"
+	errCodeUnknownUser          = "unknown_user"
+	errCodeEmailTaken           = "email_taken"
+)
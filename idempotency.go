@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotencyKeyTTL       = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+
+	// idempotencyPurgeInterval is how often expired keys are deleted
+	idempotencyPurgeInterval = time.Hour
+)
+
+// errIdempotencyKeyInUse is returned from inside a transaction when another
+// request has already stored a response under the same key, so the caller
+// can roll back its own write and replay the stored response instead.
+var errIdempotencyKeyInUse = errors.New("idempotency key already used")
+
+// storedResponse looks up an unexpired response saved under key for userID.
+// It returns sql.ErrNoRows if there is none.
+func storedResponse(ctx context.Context, queries *database.Queries, userID uuid.UUID, key string) (database.IdempotencyKey, error) {
+	return queries.GetIdempotencyKey(ctx, database.GetIdempotencyKeyParams{
+		UserID:    userID,
+		Key:       key,
+		ExpiresAt: time.Now().UTC(),
+	})
+}
+
+// replayResponse writes a response saved by an earlier request with the
+// same idempotency key, in the format that request negotiated.
+func replayResponse(w http.ResponseWriter, stored database.IdempotencyKey) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", stored.ContentType)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(stored.StatusCode))
+	w.Write(stored.ResponseBody)
+}
+
+// purgeIdempotencyKeys deletes expired keys every interval until ctx is
+// done. Expired rows are otherwise only overwritten when the same key is
+// reused, so without this the table grows without bound.
+func purgeIdempotencyKeys(ctx context.Context, queries *database.Queries, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := queries.DeleteExpiredIdempotencyKeys(ctx, time.Now().UTC())
+			if err != nil {
+				slog.ErrorContext(ctx, "Error purging idempotency keys", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				slog.InfoContext(ctx, "Purged expired idempotency keys", "deleted", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+)
+
+func TestReplayResponseUsesStoredContentType(t *testing.T) {
+	stored := database.IdempotencyKey{
+		StatusCode:   http.StatusCreated,
+		ResponseBody: []byte("<chirp></chirp>"),
+		ContentType:  "application/xml",
+	}
+
+	rec := httptest.NewRecorder()
+	replayResponse(rec, stored)
+
+	if rec.Code != http.StatusCreated {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("Content-Type = %q, want application/xml", ct)
+	}
+	if got := rec.Header().Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("Idempotent-Replayed = %q, want true", got)
+	}
+	if got := rec.Body.String(); got != "<chirp></chirp>" {
+		t.Errorf("body = %q, want stored body", got)
+	}
+}
@@ -0,0 +1,141 @@
+// Package validate checks request structs against rules declared in
+// `validate` struct tags, such as `validate:"required,email"`.
+//
+// Supported rules:
+//
+//	required  the field must not be its zero value (or nil, for pointers)
+//	email     a non-empty string must be a bare email address
+//	timezone  a non-empty string must be an IANA time zone name
+//
+// Rules other than required are skipped for empty values, so optional
+// fields only need to be valid when present. Pointer fields are checked
+// through the pointer.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// FieldError describes why one field failed validation. Field is the
+// field's JSON name.
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Message string `json:"message" xml:"message"`
+}
+
+// Errors is every FieldError found in a struct, in field order.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Struct validates v, a struct or pointer to one, returning Errors if any
+// field breaks its rules. It panics on an unknown rule, since that is a
+// mistake in the tag rather than in the input.
+func Struct(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	var errs Errors
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		value := rv.Field(i)
+		for _, rule := range strings.Split(tag, ",") {
+			if msg := check(rule, value); msg != "" {
+				errs = append(errs, FieldError{Field: jsonName(field), Message: msg})
+				// Report only the first broken rule per field
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check applies rule to value and returns a message if it fails.
+func check(rule string, value reflect.Value) string {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			if rule == "required" {
+				return "is required"
+			}
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	if rule == "required" {
+		if value.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+	if value.IsZero() {
+		return ""
+	}
+
+	switch rule {
+	case "email":
+		if err := Email(value.String()); err != nil {
+			return "must be a valid email address"
+		}
+	case "timezone":
+		if err := Timezone(value.String()); err != nil {
+			return "must be an IANA time zone name"
+		}
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", rule))
+	}
+	return ""
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// Email checks that email is a bare address such as "user@example.com".
+func Email(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	// ParseAddress also accepts forms like "Name <user@example.com>"
+	if addr.Address != email {
+		return fmt.Errorf("email must be a bare address")
+	}
+	return nil
+}
+
+// Timezone checks that tz is an IANA time zone name.
+func Timezone(tz string) error {
+	// LoadLocation also accepts "" and "Local", which mean nothing to a
+	// client
+	if tz == "" || tz == "Local" {
+		return fmt.Errorf("invalid timezone %q", tz)
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("invalid timezone %q", tz)
+	}
+	return nil
+}
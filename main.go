@@ -1,49 +1,288 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
+	_ "time/tzdata" // so timezone validation works without system zoneinfo
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+	"github.com/NishanthPrem/go_chirpy/internal/validate"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// likeEscaper escapes LIKE wildcards so search terms match literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+var defaultProfaneWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// defaultWelcomeMessage is served at /app unless WELCOME_MESSAGE is set.
+const defaultWelcomeMessage = "Welcome to Chirpy"
+
+// defaultProfaneReplacement masks profane words unless PROFANE_REPLACEMENT
+// is set.
+const defaultProfaneReplacement = "****"
+
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 60 * 24 * time.Hour
+
+	verificationTokenTTL  = 24 * time.Hour
+	passwordResetTokenTTL = time.Hour
+
+	defaultChirpsLimit = 50
+	maxChirpsLimit     = 100
+	maxChirpsBatch     = 100
+	maxChirpsLookup    = 100
+
+	defaultUsersLimit = 50
+	maxUsersLimit     = 100
+
+	// chirpsParentFKey is the foreign key from chirps.parent_id to chirps.id
+	chirpsParentFKey = "chirps_parent_id_fkey"
+
+	shutdownTimeout  = 30 * time.Second
+	readinessTimeout = 2 * time.Second
+
+	pingInitialBackoff = 500 * time.Millisecond
+	pingMaxBackoff     = 30 * time.Second
+
+	// maintenanceRetryAfter is the Retry-After sent while in maintenance mode.
+	maintenanceRetryAfter = 2 * time.Minute
 )
 
 type apiConfig struct {
-	fileServerHits atomic.Int32
+	fileServerHits       atomic.Int32
+	bcryptCost           int
+	dummyPasswordHash    string
+	jwtSecret            string
+	jwtIssuer            string
+	jwtAudience          string
+	polkaKey             string
+	platform             string
+	profaneWords         []string
+	profaneReplacement   string
+	queryTimeout         time.Duration
+	maxBodyBytes         int64
+	softDelete           bool
+	chirpMaxLength       int
+	maxChirpsPerUser     int
+	broker               *chirpBroker
+	requireVerifiedEmail bool
+	maintenance          atomic.Bool
+	adminKey             string
+	slowQueryThreshold   time.Duration
+	basePath             string
 }
 
+// Response types carry xml tags as well as json ones for clients that ask
+// for XML; see respondWith.
 type Chirp struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    string    `json:"user_id"`
+	XMLName    xml.Name      `json:"-" xml:"chirp"`
+	ID         uuid.UUID     `json:"id" xml:"id"`
+	CreatedAt  time.Time     `json:"created_at" xml:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at" xml:"updated_at"`
+	Body       string        `json:"body" xml:"body"`
+	UserID     uuid.UUID     `json:"user_id" xml:"user_id"`
+	ParentID   uuid.NullUUID `json:"parent_id" xml:"-"`
+	Timezone   *string       `json:"timezone" xml:"timezone,omitempty"`
+	IsPinned   bool          `json:"is_pinned" xml:"is_pinned"`
+	LikeCount  *int64        `json:"like_count,omitempty" xml:"like_count,omitempty"`
+	ReplyCount *int64        `json:"reply_count,omitempty" xml:"reply_count,omitempty"`
+	Author     *ChirpAuthor  `json:"author,omitempty" xml:"author,omitempty"`
+}
+
+// MarshalXML leaves parent_id out of top-level chirps, for which
+// uuid.NullUUID would otherwise write the text "null".
+func (c Chirp) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	// plainChirp drops this method so encoding it doesn't recurse
+	type plainChirp Chirp
+	out := struct {
+		plainChirp
+		ParentID *uuid.UUID `xml:"parent_id,omitempty"`
+	}{plainChirp: plainChirp(c)}
+	if c.ParentID.Valid {
+		out.ParentID = &c.ParentID.UUID
+	}
+	// The embedded XMLName no longer names the element, so name it here
+	start.Name = xml.Name{Local: "chirp"}
+	return e.EncodeElement(out, start)
+}
+
+// ChirpPage is the envelope=true form of a chirp list.
+type ChirpPage struct {
+	XMLName xml.Name `json:"-" xml:"page"`
+	Chirps  []Chirp  `json:"chirps" xml:"chirps>chirp"`
+	Limit   int      `json:"limit" xml:"limit"`
+	Offset  int      `json:"offset" xml:"offset"`
+	Total   int64    `json:"total" xml:"total"`
+}
+
+// ChirpAuthor is the author summary nested in a chirp with include=author.
+type ChirpAuthor struct {
+	ID    uuid.UUID `json:"id" xml:"id"`
+	Email string    `json:"email" xml:"email"`
+}
+
+type ChirpLookupRequest struct {
+	IDs []uuid.UUID `json:"ids" validate:"required"`
 }
 
 type ChirpRequest struct {
-	Body   string `json:"body"`
-	UserID string `json:"user_id"`
+	Body     string        `json:"body" validate:"required"`
+	ParentID uuid.NullUUID `json:"parent_id"`
+	Timezone string        `json:"timezone" validate:"timezone"`
+}
+
+type ChirpValidationResponse struct {
+	XMLName     xml.Name `json:"-" xml:"validation"`
+	Valid       bool     `json:"valid" xml:"valid"`
+	CleanedBody string   `json:"cleaned_body" xml:"cleaned_body"`
 }
 
 type User struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Email     string    `json:"email"`
+	XMLName       xml.Name  `json:"-" xml:"user"`
+	ID            uuid.UUID `json:"id" xml:"id"`
+	CreatedAt     time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" xml:"updated_at"`
+	Email         string    `json:"email" xml:"email"`
+	IsChirpyRed   bool      `json:"is_chirpy_red" xml:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified" xml:"email_verified"`
 }
 
 type UserRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// UserPatchRequest uses pointers so an absent field can be told apart from
+// an empty one.
+type UserPatchRequest struct {
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+}
+
+type VerifyRequest struct {
+	Token string `json:"token"`
+}
+
+type PasswordResetRequest struct {
 	Email string `json:"email"`
 }
 
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	XMLName xml.Name `json:"-" xml:"login"`
+	User
+	Token        string `json:"token" xml:"token"`
+	RefreshToken string `json:"refresh_token" xml:"refresh_token"`
+}
+
+type TokenResponse struct {
+	XMLName xml.Name `json:"-" xml:"token"`
+	Token   string   `json:"token" xml:"token"`
+}
+
+type PolkaWebhookRequest struct {
+	Event string `json:"event"`
+	Data  struct {
+		UserID string `json:"user_id"`
+	} `json:"data"`
+}
+
+type MaintenanceRequest struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+}
+
+// chirpFromDB and userFromDB normalize timestamps to UTC, since the driver
+// may hand them back in a different zone than they were written in. Chirps
+// start with a like count of zero for callers to fill in.
+func chirpFromDB(c database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:        c.ID,
+		CreatedAt: c.CreatedAt.UTC(),
+		UpdatedAt: c.UpdatedAt.UTC(),
+		Body:      c.Body,
+		UserID:    c.UserID,
+		ParentID:  c.ParentID,
+		IsPinned:  c.IsPinned,
+		LikeCount: new(int64),
+	}
+	if c.Timezone.Valid {
+		chirp.Timezone = &c.Timezone.String
+	}
+	return chirp
+}
+
+func userFromDB(u database.User) User {
+	return User{
+		ID:            u.ID,
+		CreatedAt:     u.CreatedAt.UTC(),
+		UpdatedAt:     u.UpdatedAt.UTC(),
+		Email:         u.Email,
+		IsChirpyRed:   u.IsChirpyRed,
+		EmailVerified: u.EmailVerified,
+	}
+}
+
+// authenticate validates the request's access token and returns the user ID
+// it was issued for. On failure it writes a 401 and returns false.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	token, err := getBearerToken(r)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Missing or malformed token")
+		return uuid.Nil, false
+	}
+
+	subject, err := validateJWT(token, cfg.jwtSecret, cfg.jwtIssuer, cfg.jwtAudience)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid or expired token")
+		return uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(subject)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid or expired token")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cfg.fileServerHits.Add(1)
@@ -51,23 +290,61 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
-func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, `<!DOCTYPE html>
+func (cfg *apiConfig) metricsHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		// A failed count shouldn't take the whole page down
+		chirpCount := "unavailable"
+		if n, err := queries.CountChirps(ctx, database.CountChirpsParams{}); err != nil {
+			slog.ErrorContext(ctx, "Error counting chirps", "error", err)
+		} else {
+			chirpCount = strconv.FormatInt(n, 10)
+		}
+
+		userCount := "unavailable"
+		if n, err := queries.CountUsers(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error counting users", "error", err)
+		} else {
+			userCount = strconv.FormatInt(n, 10)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html>
 <html>
   <body>
     <h1>Welcome, Chirpy Admin</h1>
     <p>Chirpy has been visited %d times!</p>
+    <p>Chirps: %s</p>
+    <p>Users: %s</p>
   </body>
-</html>`, cfg.fileServerHits.Load())
+</html>`, cfg.fileServerHits.Load(), chirpCount, userCount)
+	}
 }
 
 func (cfg *apiConfig) resetHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, err := db.Exec("DELETE FROM users")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		if cfg.platform != "dev" {
+			respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "Reset is only allowed in dev")
+			return
+		}
+
+		// Users' chirps would go with them anyway, but clearing chirps
+		// first doesn't depend on the cascade
+		err := withTx(ctx, db, func(tx *sql.Tx) error {
+			qtx := database.New(cfg.logSlowQueries(tx))
+			if err := qtx.DeleteChirps(ctx); err != nil {
+				return err
+			}
+			return qtx.DeleteUsers(ctx)
+		})
 		if err != nil {
-			log.Printf("Failed to delete users: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "Failed to reset", "error", err)
+			respondWithDBError(w, r, ctx, "Could not reset")
 			return
 		}
 
@@ -76,228 +353,2643 @@ func (cfg *apiConfig) resetHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func validateChirp(body string) error {
-	if len(body) > 140 {
-		return fmt.Errorf("chirp is too long")
-	}
-	if len(body) == 0 {
-		return fmt.Errorf("chirp body cannot be empty")
-	}
-	return nil
+// resetMetricsHandler zeroes the hit counter without touching any data, so
+// unlike reset it is available on every platform.
+func (cfg *apiConfig) resetMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.fileServerHits.Store(0)
+	w.WriteHeader(http.StatusOK)
 }
 
-func cleanChirpBody(body string) string {
-	profaneWords := []string{"kerfuffle", "sharbert", "fornax"}
-	words := strings.Fields(body)
+// adminDeleteChirpsHandler deletes every chirp, leaving users in place. Like
+// reset, it is only available on the dev platform.
+func (cfg *apiConfig) adminDeleteChirpsHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
 
-	for i, word := range words {
-		for _, profane := range profaneWords {
-			if strings.ToLower(word) == profane {
-				words[i] = "****"
-				break
-			}
+		if cfg.platform != "dev" {
+			respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "Deleting chirps is only allowed in dev")
+			return
 		}
-	}
 
-	return strings.Join(words, " ")
+		if err := queries.DeleteChirps(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete chirps", "error", err)
+			respondWithDBError(w, r, ctx, "Could not delete chirps")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
 }
 
-func saveChirp(db *sql.DB, chirp Chirp) error {
-	_, err := db.Exec(
-		"INSERT INTO chirps (id, created_at, updated_at, body, user_id) VALUES ($1, $2, $3, $4, $5)",
-		chirp.ID,
-		chirp.CreatedAt,
-		chirp.UpdatedAt,
-		chirp.Body,
-		chirp.UserID,
-	)
-	return err
+// maintenanceHandler reports whether maintenance mode is on.
+func (cfg *apiConfig) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	respondWith(w, r, http.StatusOK, map[string]bool{"enabled": cfg.maintenance.Load()})
 }
 
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(payload)
+// setMaintenanceHandler turns maintenance mode on or off at runtime. Unlike
+// the dev-only admin routes it is meant for production deploys, so it is
+// guarded by ADMIN_API_KEY and disabled when that is unset.
+func (cfg *apiConfig) setMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdminKey(w, r, "Maintenance toggle") {
+		return
+	}
+
+	var req MaintenanceRequest
+	if !cfg.decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		respondWithValidationError(w, r, err)
+		return
+	}
+
+	cfg.maintenance.Store(*req.Enabled)
+	slog.InfoContext(r.Context(), "Maintenance mode changed", "enabled", *req.Enabled)
+	respondWith(w, r, http.StatusOK, map[string]bool{"enabled": *req.Enabled})
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, map[string]string{"error": message})
+// requireAdminKey checks that the request carries ADMIN_API_KEY, answering
+// 403 if no key is configured and 401 if it doesn't match. feature names the
+// endpoint in the 403 message.
+func (cfg *apiConfig) requireAdminKey(w http.ResponseWriter, r *http.Request, feature string) bool {
+	if cfg.adminKey == "" {
+		respondWithError(w, r, http.StatusForbidden, errCodeForbidden, feature+" requires ADMIN_API_KEY")
+		return false
+	}
+
+	apiKey, err := getAPIKey(r)
+	if err != nil || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminKey)) != 1 {
+		respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid API key")
+		return false
+	}
+	return true
 }
 
-func createChirpHandler(db *sql.DB) http.HandlerFunc {
+// adminLogoutUserHandler revokes every refresh token a user holds, forcing
+// them to log in again once their current access token expires. It is open
+// on the dev platform and needs ADMIN_API_KEY elsewhere.
+func (cfg *apiConfig) adminLogoutUserHandler(queries *database.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req ChirpRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		if cfg.platform != "dev" && !cfg.requireAdminKey(w, r, "Logging out users") {
 			return
 		}
 
-		if err := validateChirp(req.Body); err != nil {
-			respondWithError(w, http.StatusBadRequest, err.Error())
+		userID, err := uuid.Parse(r.PathValue("userID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid user id")
 			return
 		}
 
-		cleanedBody := cleanChirpBody(req.Body)
-
-		chirp := Chirp{
-			ID:        uuid.New().String(),
-			CreatedAt: time.Now().UTC(),
-			UpdatedAt: time.Now().UTC(),
-			Body:      cleanedBody,
-			UserID:    req.UserID,
+		if _, err := queries.GetUser(ctx, userID); err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not log out user")
+			return
 		}
 
-		if err := saveChirp(db, chirp); err != nil {
-			log.Printf("Error saving chirp: %v", err)
-			respondWithError(w, http.StatusInternalServerError, "Could not save chirp")
+		now := time.Now().UTC()
+		revoked, err := queries.RevokeUserRefreshTokens(ctx, database.RevokeUserRefreshTokensParams{
+			RevokedAt: sql.NullTime{Time: now, Valid: true},
+			UpdatedAt: now,
+			UserID:    userID,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error revoking refresh tokens", "error", err)
+			respondWithDBError(w, r, ctx, "Could not log out user")
 			return
 		}
 
-		respondWithJSON(w, http.StatusCreated, chirp)
+		slog.InfoContext(ctx, "Revoked user's refresh tokens", "user_id", userID, "revoked", revoked)
+		respondWith(w, r, http.StatusOK, map[string]int64{"revoked": revoked})
 	}
 }
 
-func getChirpHandler(db *sql.DB) http.HandlerFunc {
+// adminUsersHandler lists every user for local debugging. Like reset, it is
+// only available on the dev platform.
+func (cfg *apiConfig) adminUsersHandler(queries *database.Queries) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := db.Query("SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC")
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		if cfg.platform != "dev" {
+			respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "Listing users is only allowed in dev")
+			return
+		}
+
+		dbUsers, err := queries.GetUsers(ctx)
 		if err != nil {
-			log.Printf("Error fetching chirps: %v", err)
-			respondWithError(w, http.StatusInternalServerError, "Could not retrieve chirps")
+			slog.ErrorContext(ctx, "Error fetching users", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve users")
 			return
 		}
-		defer conn.Close()
 
-		var chirps []Chirp
-		for conn.Next() {
-			var chirp Chirp
-			if err := conn.Scan(&chirp.ID, &chirp.CreatedAt, &chirp.UpdatedAt, &chirp.Body, &chirp.UserID); err != nil {
-				log.Printf("Error scanning chirp: %v", err)
-				respondWithError(w, http.StatusInternalServerError, "Could not retrieve chirp")
-				return
-			}
-			chirps = append(chirps, chirp)
+		users := make([]User, 0, len(dbUsers))
+		for _, user := range dbUsers {
+			users = append(users, userFromDB(user))
 		}
-		respondWithJSON(w, http.StatusOK, chirps)
+		respondWith(w, r, http.StatusOK, users)
 	}
 }
 
-func getChirpByIDHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		chirpID := r.PathValue("chirpID")
-		var chirp Chirp
+// validateChirp checks the body length, which unlike the rules in
+// ChirpRequest's tags depends on configuration.
+func validateChirp(body string, maxLength int) error {
+	if utf8.RuneCountInString(body) > maxLength {
+		return fmt.Errorf("chirp is too long (max %d)", maxLength)
+	}
+	return nil
+}
 
-		err := db.QueryRow("SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1", chirpID).
-			Scan(&chirp.ID, &chirp.CreatedAt, &chirp.UpdatedAt, &chirp.Body, &chirp.UserID)
+func isNotAlphanumeric(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
 
-		if err == sql.ErrNoRows {
-			respondWithError(w, http.StatusNotFound, "Chirp not found")
-			return
-		} else if err != nil {
-			log.Printf("Error fetching chirp: %v", err)
-			respondWithError(w, http.StatusInternalServerError, "Could not retrieve chirp")
-			return
+func cleanChirpBody(body string, profaneWords []string, replacement string) string {
+	words := strings.Fields(body)
+
+	for i, word := range words {
+		// Match on the alphanumeric core so "Sharbert!" is caught, then put
+		// the surrounding punctuation back
+		core := strings.TrimFunc(word, isNotAlphanumeric)
+		if core == "" {
+			continue
 		}
+		start := strings.Index(word, core)
+		prefix, suffix := word[:start], word[start+len(core):]
 
-		respondWithJSON(w, http.StatusOK, chirp)
+		// EqualFold compares by Unicode case folding, which handles
+		// letters that lowercasing maps inconsistently
+		for _, profane := range profaneWords {
+			if strings.EqualFold(core, profane) {
+				words[i] = prefix + replacement + suffix
+				break
+			}
+		}
 	}
+
+	return strings.Join(words, " ")
 }
 
-func createUserHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var req UserRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
-			return
-		}
+// isUniqueViolation reports whether err is a Postgres unique constraint error.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
 
-		user := User{
-			ID:        uuid.New().String(),
-			CreatedAt: time.Now().UTC(),
-			UpdatedAt: time.Now().UTC(),
-			Email:     req.Email,
-		}
+// isForeignKeyViolation reports whether err is a Postgres foreign key
+// constraint error.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
 
-		_, err := db.Exec(
-			"INSERT INTO users (id, created_at, updated_at, email) VALUES ($1, $2, $3, $4)",
-			user.ID,
-			user.CreatedAt,
-			user.UpdatedAt,
-			user.Email,
-		)
+// violatedConstraint returns the name of the constraint a Postgres error
+// refers to, or "" if there is none.
+func violatedConstraint(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Constraint
+	}
+	return ""
+}
 
-		if err != nil {
-			log.Printf("Error creating user: %v", err)
-			respondWithError(w, http.StatusInternalServerError, "Could not create user")
-			return
-		}
+// errChirpLimitReached is returned from inside a transaction when saving
+// would take a user past cfg.maxChirpsPerUser.
+var errChirpLimitReached = errors.New("chirp limit reached")
 
-		respondWithJSON(w, http.StatusCreated, user)
+// checkChirpLimit returns errChirpLimitReached if n more chirps would take
+// userID past cfg.maxChirpsPerUser. It must run in the transaction that
+// saves them: it locks the user's row so concurrent creates for the same
+// user wait their turn instead of both passing the count.
+func (cfg *apiConfig) checkChirpLimit(ctx context.Context, qtx *database.Queries, userID uuid.UUID, n int) error {
+	if cfg.maxChirpsPerUser == 0 {
+		return nil
 	}
-}
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	if err := qtx.LockUser(ctx, userID); err != nil {
+		return err
+	}
+	count, err := qtx.CountChirps(ctx, database.CountChirpsParams{
+		AuthorID: uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if count+int64(n) > int64(cfg.maxChirpsPerUser) {
+		return errChirpLimitReached
+	}
+	return nil
 }
 
-func main() {
-	apiCfg := apiConfig{}
+// parentExists reports whether parentID, if set, refers to a chirp that
+// hasn't been deleted. An unset parentID always exists.
+func parentExists(ctx context.Context, queries *database.Queries, parentID uuid.NullUUID) (bool, error) {
+	if !parentID.Valid {
+		return true, nil
+	}
+	_, err := queries.GetChirp(ctx, parentID.UUID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
 
-	err := godotenv.Load()
+// withTx runs fn inside a transaction, committing if fn returns nil and
+// rolling back otherwise, so multi-step writes either all land or none do.
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return err
 	}
-	dbURL := os.Getenv("DB_URL")
-	if dbURL == "" {
-		log.Fatal("DB_URL environment variable is not set")
+	// Rollback is a no-op once Commit has succeeded
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
-	db, err := sql.Open("postgres", dbURL)
+// respondWith writes payload as JSON, or as XML if the request's Accept
+// header prefers application/xml.
+func respondWith(w http.ResponseWriter, r *http.Request, status int, payload interface{}) {
+	contentType, body, err := marshalBody(r, payload)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	defer db.Close()
 
-	// Test database connection
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// respondWithETag writes payload as a 200 response with a weak ETag
+// computed from the body, or a bodiless 304 if the request's If-None-Match
+// already has it. HEAD requests get the same headers, Content-Length
+// included, without the body.
+func respondWithETag(w http.ResponseWriter, r *http.Request, payload interface{}) {
+	contentType, body, err := marshalBody(r, payload)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	w.Header().Add("Vary", "Accept")
 
-	mux := http.NewServeMux()
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
 
-	// Static file server with metrics
-	fileServer := http.FileServer(http.Dir("./assets"))
-	mux.Handle("/app/assets/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app/assets/", fileServer)))
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if match = strings.TrimSpace(match); match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
-	// API routes
-	mux.HandleFunc("GET /api/healthz", healthHandler)
-	mux.HandleFunc("GET /api/chirps", getChirpHandler(db))
-	mux.HandleFunc("GET /api/chirps/{chirpID}", getChirpByIDHandler(db))
-	mux.HandleFunc("POST /api/users", createUserHandler(db))
-	mux.HandleFunc("POST /api/chirps", createChirpHandler(db))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
 
-	// Admin routes
-	mux.HandleFunc("GET /admin/metrics", apiCfg.metricsHandler)
-	mux.HandleFunc("POST /admin/reset", apiCfg.resetHandler(db))
+// respondWithError writes an error with a human-readable message and code,
+// one of the errCode constants, for clients to branch on.
+func respondWithError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	respondWith(w, r, status, map[string]string{"error": message, "code": code})
+}
 
-	// Welcome route
-	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Welcome to Chirpy"))
+// decodeJSON decodes the request body into dst, rejecting non-JSON content
+// types, unknown fields and bodies larger than cfg.maxBodyBytes. On failure
+// it writes the error response and returns false.
+func (cfg *apiConfig) decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		respondWithError(w, r, http.StatusUnsupportedMediaType, errCodeUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(dst); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, r, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "Request body too large")
+			return false
+		}
+		var syntaxErr *json.SyntaxError
+		switch {
+		case errors.Is(err, io.EOF):
+			respondWithError(w, r, http.StatusBadRequest, errCodeMalformedBody, "request body is required")
+		case errors.As(err, &syntaxErr):
+			respondWithError(w, r, http.StatusBadRequest, errCodeMalformedBody, fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset))
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			respondWithError(w, r, http.StatusBadRequest, errCodeMalformedBody, "malformed JSON")
+		default:
+			respondWithError(w, r, http.StatusBadRequest, errCodeMalformedBody, "Invalid request payload")
+		}
+		return false
+	}
+	return true
+}
+
+// pingWithRetry pings db up to attempts times, doubling the wait between
+// tries, and returns the last error if none succeed.
+func pingWithRetry(db *sql.DB, attempts int) error {
+	backoff := pingInitialBackoff
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = db.Ping(); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		slog.Warn("Database not ready, retrying",
+			"attempt", attempt,
+			"max_attempts", attempts,
+			"backoff", backoff,
+			"error", err,
+		)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, pingMaxBackoff)
+	}
+	return err
+}
+
+// parsePagination reads the limit and offset query parameters, capping limit
+// at maxLimit. On invalid input it writes a 400 and returns false.
+func parsePagination(w http.ResponseWriter, r *http.Request, defaultLimit, maxLimit int) (limit, offset int, ok bool) {
+	limit = defaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 0 {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid limit, must be a non-negative integer")
+			return 0, 0, false
+		}
+		limit = min(n, maxLimit)
+	}
+
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid offset, must be a non-negative integer")
+			return 0, 0, false
+		}
+		offset = min(n, math.MaxInt32)
+	}
+	return limit, offset, true
+}
+
+// parseIncludeAuthor reports whether the include query parameter, a
+// comma-separated list, asks for author details. On an unknown value it
+// writes a 400 and returns false.
+func parseIncludeAuthor(w http.ResponseWriter, r *http.Request) (includeAuthor, ok bool) {
+	include := r.URL.Query().Get("include")
+	if include == "" {
+		return false, true
+	}
+	for _, field := range strings.Split(include, ",") {
+		if field != "author" {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid include, must be author")
+			return false, false
+		}
+		includeAuthor = true
+	}
+	return includeAuthor, true
+}
+
+// parseExpand reads the expand query parameter, a comma-separated list of
+// counts to attach to each chirp in a list. On an unknown value it writes a
+// 400 and returns false.
+func parseExpand(w http.ResponseWriter, r *http.Request) (expandLikes, expandReplies, ok bool) {
+	expand := r.URL.Query().Get("expand")
+	if expand == "" {
+		return false, false, true
+	}
+	for _, field := range strings.Split(expand, ",") {
+		switch field {
+		case "likes":
+			expandLikes = true
+		case "replies":
+			expandReplies = true
+		default:
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid expand, must be likes or replies")
+			return false, false, false
+		}
+	}
+	return expandLikes, expandReplies, true
+}
+
+// respondWithValidationError reports the field errors from validate.Struct.
+func respondWithValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	respondWith(w, r, http.StatusBadRequest, map[string]interface{}{
+		"error":  "validation failed",
+		"code":   errCodeValidationFailed,
+		"fields": err,
 	})
+}
 
-	// Start server
-	srv := &http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+// respondWithDBError reports a failed query: 504 if ctx's deadline passed
+// while it ran, otherwise a 500 with message.
+func respondWithDBError(w http.ResponseWriter, r *http.Request, ctx context.Context, message string) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		respondWithError(w, r, http.StatusGatewayTimeout, errCodeTimeout, "Request timed out")
+		return
 	}
+	respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, message)
+}
+
+// createChirpHandler creates a chirp for the authenticated user. If the
+// request carries an Idempotency-Key, the response is stored alongside the
+// chirp and replayed for repeats of that key instead of inserting again.
+func (cfg *apiConfig) createChirpHandler(db *sql.DB, queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		if !cfg.requireVerified(w, r, ctx, queries, userID) {
+			return
+		}
+
+		idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Idempotency-Key is too long")
+			return
+		}
+		if idempotencyKey != "" {
+			stored, err := storedResponse(ctx, queries, userID, idempotencyKey)
+			if err == nil {
+				replayResponse(w, stored)
+				return
+			} else if err != sql.ErrNoRows {
+				slog.ErrorContext(ctx, "Error fetching idempotency key", "error", err)
+				respondWithDBError(w, r, ctx, "Could not save chirp")
+				return
+			}
+		}
+
+		var req ChirpRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		// Trim before validating so padding doesn't count toward the length
+		// and a body of only spaces fails as empty
+		req.Body = strings.TrimSpace(req.Body)
+		if err := validate.Struct(req); err != nil {
+			respondWithValidationError(w, r, err)
+			return
+		}
+
+		if err := validateChirp(req.Body, cfg.chirpMaxLength); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeChirpTooLong, err.Error())
+			return
+		}
+
+		if ok, err := parentExists(ctx, queries, req.ParentID); err != nil {
+			slog.ErrorContext(ctx, "Error fetching parent chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not save chirp")
+			return
+		} else if !ok {
+			respondWithError(w, r, http.StatusBadRequest, errCodeParentNotFound, "parent chirp not found")
+			return
+		}
+
+		now := time.Now().UTC()
+		params := database.CreateChirpParams{
+			ID:        uuid.New(),
+			CreatedAt: now,
+			UpdatedAt: now,
+			Body:      cleanChirpBody(req.Body, cfg.profaneWords, cfg.profaneReplacement),
+			UserID:    userID,
+			ParentID:  req.ParentID,
+			Timezone:  sql.NullString{String: req.Timezone, Valid: req.Timezone != ""},
+		}
+
+		var chirp database.Chirp
+		var err error
+		if idempotencyKey == "" && cfg.maxChirpsPerUser == 0 {
+			chirp, err = queries.CreateChirp(ctx, params)
+		} else {
+			err = withTx(ctx, db, func(tx *sql.Tx) error {
+				qtx := database.New(cfg.logSlowQueries(tx))
+				if err := cfg.checkChirpLimit(ctx, qtx, userID, 1); err != nil {
+					return err
+				}
+
+				chirp, err = qtx.CreateChirp(ctx, params)
+				if err != nil {
+					return err
+				}
+				if idempotencyKey == "" {
+					return nil
+				}
+
+				// Store the body in the format this request negotiated so a
+				// replay matches what the original client received
+				contentType, body, err := marshalBody(r, chirpFromDB(chirp))
+				if err != nil {
+					return err
+				}
+				saved, err := qtx.SaveIdempotencyKey(ctx, database.SaveIdempotencyKeyParams{
+					UserID:       userID,
+					Key:          idempotencyKey,
+					CreatedAt:    now,
+					ExpiresAt:    now.Add(idempotencyKeyTTL),
+					StatusCode:   http.StatusCreated,
+					ResponseBody: body,
+					ContentType:  contentType,
+				})
+				if err != nil {
+					return err
+				}
+				if saved == 0 {
+					return errIdempotencyKeyInUse
+				}
+				return nil
+			})
+		}
+		if errors.Is(err, errIdempotencyKeyInUse) {
+			// A concurrent request with the same key committed first
+			stored, err := storedResponse(ctx, queries, userID, idempotencyKey)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error fetching idempotency key", "error", err)
+				respondWithDBError(w, r, ctx, "Could not save chirp")
+				return
+			}
+			replayResponse(w, stored)
+			return
+		} else if errors.Is(err, errChirpLimitReached) {
+			respondWithError(w, r, http.StatusForbidden, errCodeChirpLimitReached, "chirp limit reached")
+			return
+		} else if isForeignKeyViolation(err) && violatedConstraint(err) == chirpsParentFKey {
+			// The parent was deleted since it was checked above
+			respondWithError(w, r, http.StatusBadRequest, errCodeParentNotFound, "parent chirp not found")
+			return
+		} else if isForeignKeyViolation(err) {
+			// The token was valid but its user has since been deleted
+			respondWithError(w, r, http.StatusBadRequest, errCodeUnknownUser, "unknown user")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error saving chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not save chirp")
+			return
+		}
+
+		cfg.broker.publish(chirpFromDB(chirp))
+
+		w.Header().Set("Location", cfg.basePath+"/api/chirps/"+chirp.ID.String())
+		respondWith(w, r, http.StatusCreated, chirpFromDB(chirp))
+	}
+}
+
+// createChirpsBatchHandler creates up to maxChirpsBatch chirps, or fewer if
+// the rate limit is lower, for the authenticated user in one transaction. If any body is invalid nothing is
+// created and the index of the first bad chirp is returned.
+func (cfg *apiConfig) createChirpsBatchHandler(db *sql.DB, queries *database.Queries, limiter *rateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		if !cfg.requireVerified(w, r, ctx, queries, userID) {
+			return
+		}
+
+		var reqs []ChirpRequest
+		if !cfg.decodeJSON(w, r, &reqs) {
+			return
+		}
+
+		if len(reqs) == 0 {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "batch must contain at least one chirp")
+			return
+		}
+		// A batch bigger than the rate limit's burst could never be let
+		// through, so it's rejected as invalid rather than rate limited
+		maxBatch := min(maxChirpsBatch, limiter.burst())
+		if len(reqs) > maxBatch {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("batch cannot contain more than %d chirps", maxBatch))
+			return
+		}
+
+		for i := range reqs {
+			reqs[i].Body = strings.TrimSpace(reqs[i].Body)
+			req := reqs[i]
+			if err := validate.Struct(req); err != nil {
+				respondWith(w, r, http.StatusBadRequest, map[string]interface{}{
+					"error":  "validation failed",
+					"code":   errCodeValidationFailed,
+					"fields": err,
+					"index":  i,
+				})
+				return
+			}
+
+			if err := validateChirp(req.Body, cfg.chirpMaxLength); err != nil {
+				respondWith(w, r, http.StatusBadRequest, map[string]interface{}{
+					"error": err.Error(),
+					"code":  errCodeChirpTooLong,
+					"index": i,
+				})
+				return
+			}
+
+			if ok, err := parentExists(ctx, queries, req.ParentID); err != nil {
+				slog.ErrorContext(ctx, "Error fetching parent chirp", "error", err)
+				respondWithDBError(w, r, ctx, "Could not save chirps")
+				return
+			} else if !ok {
+				respondWith(w, r, http.StatusBadRequest, map[string]interface{}{
+					"error": "parent chirp not found",
+					"code":  errCodeParentNotFound,
+					"index": i,
+				})
+				return
+			}
+		}
+
+		// Each chirp in the batch counts against the same limit as a single
+		// create, so batching can't be used to get around it
+		if ok, wait := limiter.allowN(clientIP(r), len(reqs)); !ok {
+			rateLimited(w, r, wait)
+			return
+		}
+
+		chirps := make([]Chirp, 0, len(reqs))
+		err := withTx(ctx, db, func(tx *sql.Tx) error {
+			qtx := database.New(cfg.logSlowQueries(tx))
+			if err := cfg.checkChirpLimit(ctx, qtx, userID, len(reqs)); err != nil {
+				return err
+			}
+
+			now := time.Now().UTC()
+			for _, req := range reqs {
+				chirp, err := qtx.CreateChirp(ctx, database.CreateChirpParams{
+					ID:        uuid.New(),
+					CreatedAt: now,
+					UpdatedAt: now,
+					Body:      cleanChirpBody(req.Body, cfg.profaneWords, cfg.profaneReplacement),
+					UserID:    userID,
+					ParentID:  req.ParentID,
+					Timezone:  sql.NullString{String: req.Timezone, Valid: req.Timezone != ""},
+				})
+				if err != nil {
+					return err
+				}
+				chirps = append(chirps, chirpFromDB(chirp))
+			}
+			return nil
+		})
+		if errors.Is(err, errChirpLimitReached) {
+			respondWithError(w, r, http.StatusForbidden, errCodeChirpLimitReached, "chirp limit reached")
+			return
+		} else if isForeignKeyViolation(err) && violatedConstraint(err) == chirpsParentFKey {
+			respondWithError(w, r, http.StatusBadRequest, errCodeParentNotFound, "parent chirp not found")
+			return
+		} else if isForeignKeyViolation(err) {
+			respondWithError(w, r, http.StatusBadRequest, errCodeUnknownUser, "unknown user")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error saving chirp batch", "error", err)
+			respondWithDBError(w, r, ctx, "Could not save chirps")
+			return
+		}
+
+		for _, chirp := range chirps {
+			cfg.broker.publish(chirp)
+		}
+
+		respondWith(w, r, http.StatusCreated, chirps)
+	}
+}
+
+func (cfg *apiConfig) getChirpHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var params database.GetChirpsParams
+
+		if authorID := r.URL.Query().Get("author_id"); authorID != "" {
+			id, err := uuid.Parse(authorID)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid author_id")
+				return
+			}
+			params.AuthorID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+
+		// Stored timestamps are UTC without a zone, so compare in UTC
+		if before := r.URL.Query().Get("created_before"); before != "" {
+			t, err := time.Parse(time.RFC3339, before)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid created_before, must be RFC3339")
+				return
+			}
+			params.CreatedBefore = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+
+		if after := r.URL.Query().Get("created_after"); after != "" {
+			t, err := time.Parse(time.RFC3339, after)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid created_after, must be RFC3339")
+				return
+			}
+			params.CreatedAfter = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+
+		if q := r.URL.Query().Get("q"); q != "" {
+			params.Query = sql.NullString{String: likeEscaper.Replace(q), Valid: true}
+		}
+
+		if topLevel := r.URL.Query().Get("top_level_only"); topLevel != "" {
+			b, err := strconv.ParseBool(topLevel)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid top_level_only, must be a boolean")
+				return
+			}
+			params.TopLevelOnly = b
+		}
+
+		switch sort := r.URL.Query().Get("sort"); sort {
+		case "", "asc":
+		case "desc":
+			params.SortDesc = true
+		default:
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid sort, must be asc or desc")
+			return
+		}
+
+		limit, offset, ok := parsePagination(w, r, defaultChirpsLimit, maxChirpsLimit)
+		if !ok {
+			return
+		}
+
+		includeAuthor, ok := parseIncludeAuthor(w, r)
+		if !ok {
+			return
+		}
+
+		expandLikes, expandReplies, ok := parseExpand(w, r)
+		if !ok {
+			return
+		}
+
+		var envelope bool
+		if s := r.URL.Query().Get("envelope"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid envelope, must be a boolean")
+				return
+			}
+			envelope = b
+		}
+
+		params.Limit = int32(limit)
+		params.Offset = int32(offset)
+		// The counts and author cost a subquery per row, so they're only
+		// fetched when asked for
+		params.ExpandLikes = expandLikes
+		params.ExpandReplies = expandReplies
+		params.IncludeAuthor = includeAuthor
+
+		dbChirps, err := queries.GetChirps(ctx, params)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirps", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve chirps")
+			return
+		}
+
+		var chirps []Chirp
+		for _, row := range dbChirps {
+			// Counts are left out unless expanded, since they weren't fetched
+			chirp := chirpFromDB(row.Chirp)
+			chirp.LikeCount = nil
+			if expandLikes {
+				chirp.LikeCount = &row.LikeCount
+			}
+			if includeAuthor {
+				chirp.Author = &ChirpAuthor{ID: row.Chirp.UserID, Email: row.AuthorEmail}
+			}
+			if expandReplies {
+				chirp.ReplyCount = &row.ReplyCount
+			}
+			chirps = append(chirps, chirp)
+		}
+
+		w.Header().Set("X-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Offset", strconv.Itoa(offset))
+		if !envelope {
+			respondWith(w, r, http.StatusOK, chirps)
+			return
+		}
+
+		// Count with the same filters so total matches what paging would reach
+		total, err := queries.CountChirps(ctx, database.CountChirpsParams{
+			AuthorID:      params.AuthorID,
+			CreatedBefore: params.CreatedBefore,
+			CreatedAfter:  params.CreatedAfter,
+			Query:         params.Query,
+			TopLevelOnly:  params.TopLevelOnly,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error counting chirps", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve chirps")
+			return
+		}
+
+		if chirps == nil {
+			chirps = []Chirp{}
+		}
+		respondWith(w, r, http.StatusOK, ChirpPage{
+			Chirps: chirps,
+			Limit:  limit,
+			Offset: offset,
+			Total:  total,
+		})
+	}
+}
+
+// countChirpsHandler returns the number of chirps, optionally limited to one
+// author, without fetching the rows themselves.
+func (cfg *apiConfig) countChirpsHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var authorID uuid.NullUUID
+		if s := r.URL.Query().Get("author_id"); s != "" {
+			id, err := uuid.Parse(s)
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid author_id")
+				return
+			}
+			authorID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+
+		count, err := queries.CountChirps(ctx, database.CountChirpsParams{AuthorID: authorID})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error counting chirps", "error", err)
+			respondWithDBError(w, r, ctx, "Could not count chirps")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, map[string]int64{"count": count})
+	}
+}
+
+// feedHandler lists chirps by the users the caller follows, newest first.
+func (cfg *apiConfig) feedHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		limit, offset, ok := parsePagination(w, r, defaultChirpsLimit, maxChirpsLimit)
+		if !ok {
+			return
+		}
+
+		rows, err := queries.GetFeed(ctx, database.GetFeedParams{
+			FollowerID: userID,
+			Limit:      int32(limit),
+			Offset:     int32(offset),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching feed", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve feed")
+			return
+		}
+
+		chirps := make([]Chirp, 0, len(rows))
+		for _, row := range rows {
+			chirp := chirpFromDB(row.Chirp)
+			chirp.LikeCount = &row.LikeCount
+			chirps = append(chirps, chirp)
+		}
+
+		w.Header().Set("X-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Offset", strconv.Itoa(offset))
+		respondWith(w, r, http.StatusOK, chirps)
+	}
+}
+
+func (cfg *apiConfig) getChirpByIDHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid chirp id")
+			return
+		}
+
+		includeAuthor, ok := parseIncludeAuthor(w, r)
+		if !ok {
+			return
+		}
+
+		row, err := queries.GetChirp(ctx, chirpID)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve chirp")
+			return
+		}
+
+		chirp := chirpFromDB(row.Chirp)
+		chirp.LikeCount = &row.LikeCount
+		if includeAuthor {
+			chirp.Author = &ChirpAuthor{ID: row.Chirp.UserID, Email: row.AuthorEmail}
+		}
+		respondWithETag(w, r, chirp)
+	}
+}
+
+// validateChirpHandler runs a chirp through the same checks and censoring as
+// createChirpHandler without saving it, so clients can preview the result.
+// The parent, if any, isn't looked up, since that needs the database.
+func (cfg *apiConfig) validateChirpHandler(w http.ResponseWriter, r *http.Request) {
+	var req ChirpRequest
+	if !cfg.decodeJSON(w, r, &req) {
+		return
+	}
+
+	req.Body = strings.TrimSpace(req.Body)
+	if err := validate.Struct(req); err != nil {
+		respondWithValidationError(w, r, err)
+		return
+	}
+
+	if err := validateChirp(req.Body, cfg.chirpMaxLength); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, errCodeChirpTooLong, err.Error())
+		return
+	}
+
+	respondWith(w, r, http.StatusOK, ChirpValidationResponse{
+		Valid:       true,
+		CleanedBody: cleanChirpBody(req.Body, cfg.profaneWords, cfg.profaneReplacement),
+	})
+}
+
+// lookupChirpsHandler fetches up to maxChirpsLookup chirps by ID in one
+// query, returning them in the requested order. IDs that don't exist or were
+// deleted are left out.
+func (cfg *apiConfig) lookupChirpsHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var req ChirpLookupRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			respondWithValidationError(w, r, err)
+			return
+		}
+		if len(req.IDs) > maxChirpsLookup {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("cannot look up more than %d chirps", maxChirpsLookup))
+			return
+		}
+
+		includeAuthor, ok := parseIncludeAuthor(w, r)
+		if !ok {
+			return
+		}
+
+		rows, err := queries.GetChirpsByIDs(ctx, req.IDs)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirps", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve chirps")
+			return
+		}
+
+		byID := make(map[uuid.UUID]Chirp, len(rows))
+		for _, row := range rows {
+			chirp := chirpFromDB(row.Chirp)
+			chirp.LikeCount = &row.LikeCount
+			if includeAuthor {
+				chirp.Author = &ChirpAuthor{ID: row.Chirp.UserID, Email: row.AuthorEmail}
+			}
+			byID[chirp.ID] = chirp
+		}
+
+		// The query returns rows in no particular order, so rebuild the
+		// requested one, listing repeated IDs once
+		chirps := make([]Chirp, 0, len(rows))
+		for _, id := range req.IDs {
+			if chirp, ok := byID[id]; ok {
+				chirps = append(chirps, chirp)
+				delete(byID, id)
+			}
+		}
+		respondWith(w, r, http.StatusOK, chirps)
+	}
+}
+
+// getTodayChirpsHandler lists chirps created since midnight, oldest first.
+// Midnight is taken in the zone named by tz, defaulting to UTC, so clients
+// see their own calendar day.
+func (cfg *apiConfig) getTodayChirpsHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		loc := time.UTC
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			if err := validate.Timezone(tz); err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "Invalid tz, must be an IANA time zone")
+				return
+			}
+			loc, _ = time.LoadLocation(tz)
+		}
+
+		limit, offset, ok := parsePagination(w, r, defaultChirpsLimit, maxChirpsLimit)
+		if !ok {
+			return
+		}
+
+		now := time.Now().In(loc)
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+		rows, err := queries.GetChirpsSince(ctx, database.GetChirpsSinceParams{
+			CreatedAt: midnight.UTC(),
+			Limit:     int32(limit),
+			Offset:    int32(offset),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching today's chirps", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve chirps")
+			return
+		}
+
+		chirps := make([]Chirp, 0, len(rows))
+		for _, row := range rows {
+			chirp := chirpFromDB(row.Chirp)
+			chirp.LikeCount = &row.LikeCount
+			chirps = append(chirps, chirp)
+		}
+
+		w.Header().Set("X-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Offset", strconv.Itoa(offset))
+		respondWith(w, r, http.StatusOK, chirps)
+	}
+}
+
+// getChirpRepliesHandler lists the direct replies to a chirp, oldest first.
+func (cfg *apiConfig) getChirpRepliesHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid chirp id")
+			return
+		}
+
+		if _, err := queries.GetChirp(ctx, chirpID); err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve replies")
+			return
+		}
+
+		rows, err := queries.GetChirpReplies(ctx, uuid.NullUUID{UUID: chirpID, Valid: true})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching replies", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve replies")
+			return
+		}
+
+		replies := make([]Chirp, 0, len(rows))
+		for _, row := range rows {
+			reply := chirpFromDB(row.Chirp)
+			reply.LikeCount = &row.LikeCount
+			replies = append(replies, reply)
+		}
+		respondWith(w, r, http.StatusOK, replies)
+	}
+}
+
+func (cfg *apiConfig) updateChirpHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid chirp id")
+			return
+		}
+
+		var req ChirpRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		// Trim before validating so padding doesn't count toward the length
+		// and a body of only spaces fails as empty
+		req.Body = strings.TrimSpace(req.Body)
+		if err := validate.Struct(req); err != nil {
+			respondWithValidationError(w, r, err)
+			return
+		}
+
+		if err := validateChirp(req.Body, cfg.chirpMaxLength); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeChirpTooLong, err.Error())
+			return
+		}
+
+		row, err := queries.GetChirp(ctx, chirpID)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not update chirp")
+			return
+		}
+
+		if row.Chirp.UserID != userID {
+			respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "You can only edit your own chirps")
+			return
+		}
+
+		updated, err := queries.UpdateChirp(ctx, database.UpdateChirpParams{
+			Body:      cleanChirpBody(req.Body, cfg.profaneWords, cfg.profaneReplacement),
+			UpdatedAt: time.Now().UTC(),
+			ID:        chirpID,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error updating chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not update chirp")
+			return
+		}
+
+		// Editing doesn't touch likes, so the count fetched above still holds
+		chirp := chirpFromDB(updated)
+		chirp.LikeCount = &row.LikeCount
+		respondWith(w, r, http.StatusOK, chirp)
+	}
+}
+
+// deleteChirpHandler removes a chirp, or only marks it deleted when
+// SOFT_DELETE_CHIRPS is enabled so it can be kept for moderation.
+func (cfg *apiConfig) deleteChirpHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid chirp id")
+			return
+		}
+
+		row, err := queries.GetChirp(ctx, chirpID)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not delete chirp")
+			return
+		}
+
+		if row.Chirp.UserID != userID {
+			respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "You can only delete your own chirps")
+			return
+		}
+
+		if cfg.softDelete {
+			now := time.Now().UTC()
+			err = queries.SoftDeleteChirp(ctx, database.SoftDeleteChirpParams{
+				DeletedAt: sql.NullTime{Time: now, Valid: true},
+				UpdatedAt: now,
+				ID:        chirpID,
+			})
+		} else {
+			err = queries.DeleteChirp(ctx, chirpID)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error deleting chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not delete chirp")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// pinChirpHandler pins or unpins one of the authenticated user's chirps. A
+// user can have only one pinned chirp, enforced by a partial unique index,
+// so pinning a second answers 409 until the first is unpinned. Pinning an
+// already pinned chirp, or unpinning one that isn't, is a no-op.
+func (cfg *apiConfig) pinChirpHandler(queries *database.Queries, pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid chirp id")
+			return
+		}
+
+		row, err := queries.GetChirp(ctx, chirpID)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not update pin")
+			return
+		}
+
+		if row.Chirp.UserID != userID {
+			respondWithError(w, r, http.StatusForbidden, errCodeForbidden, "You can only pin your own chirps")
+			return
+		}
+
+		if row.Chirp.IsPinned != pinned {
+			err = queries.SetChirpPinned(ctx, database.SetChirpPinnedParams{
+				IsPinned:  pinned,
+				UpdatedAt: time.Now().UTC(),
+				ID:        chirpID,
+			})
+			if isUniqueViolation(err) {
+				respondWithError(w, r, http.StatusConflict, errCodePinLimitReached, "You already have a pinned chirp; unpin it first")
+				return
+			} else if err != nil {
+				slog.ErrorContext(ctx, "Error updating chirp pin", "error", err)
+				respondWithDBError(w, r, ctx, "Could not update pin")
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// likeChirpHandler records that the authenticated user likes a chirp. Liking
+// a chirp twice is a no-op.
+func (cfg *apiConfig) likeChirpHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid chirp id")
+			return
+		}
+
+		if _, err := queries.GetChirp(ctx, chirpID); err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not like chirp")
+			return
+		}
+
+		err = queries.CreateChirpLike(ctx, database.CreateChirpLikeParams{
+			UserID:    userID,
+			ChirpID:   chirpID,
+			CreatedAt: time.Now().UTC(),
+		})
+		if isForeignKeyViolation(err) {
+			// The chirp or the user was deleted since the lookup above
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error liking chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not like chirp")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// unlikeChirpHandler removes the authenticated user's like from a chirp, if
+// there is one.
+func (cfg *apiConfig) unlikeChirpHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid chirp id")
+			return
+		}
+
+		if _, err := queries.GetChirp(ctx, chirpID); err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "Chirp not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not unlike chirp")
+			return
+		}
+
+		err = queries.DeleteChirpLike(ctx, database.DeleteChirpLikeParams{
+			UserID:  userID,
+			ChirpID: chirpID,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error unliking chirp", "error", err)
+			respondWithDBError(w, r, ctx, "Could not unlike chirp")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// createUserHandler signs a user up and issues them an email verification
+// token. The user row and the token are written together.
+func (cfg *apiConfig) createUserHandler(db *sql.DB, queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var req UserRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			respondWithValidationError(w, r, err)
+			return
+		}
+		req.Email = strings.ToLower(req.Email)
+
+		hashedPassword, err := hashPassword(req.Password, cfg.bcryptCost)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error hashing password", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not create user")
+			return
+		}
+
+		verificationToken, err := makeRandomToken()
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating verification token", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not create user")
+			return
+		}
+
+		var user database.User
+		err = withTx(ctx, db, func(tx *sql.Tx) error {
+			qtx := database.New(cfg.logSlowQueries(tx))
+			now := time.Now().UTC()
+
+			var err error
+			user, err = qtx.CreateUser(ctx, database.CreateUserParams{
+				ID:             uuid.New(),
+				CreatedAt:      now,
+				UpdatedAt:      now,
+				Email:          req.Email,
+				HashedPassword: hashedPassword,
+			})
+			if err != nil {
+				return err
+			}
+
+			return qtx.CreateVerificationToken(ctx, database.CreateVerificationTokenParams{
+				TokenHash: hashToken(verificationToken),
+				CreatedAt: now,
+				UserID:    user.ID,
+				ExpiresAt: now.Add(verificationTokenTTL),
+			})
+		})
+		if isUniqueViolation(err) {
+			respondWithError(w, r, http.StatusConflict, errCodeEmailTaken, "email already exists")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error creating user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not create user")
+			return
+		}
+
+		// There is no mail delivery yet, so the token is only surfaced in
+		// dev where it can be read from the logs
+		if cfg.platform == "dev" {
+			slog.InfoContext(ctx, "Email verification token issued", "user_id", user.ID, "token", verificationToken)
+		}
+
+		w.Header().Set("Location", cfg.basePath+"/api/users/"+user.ID.String())
+		respondWith(w, r, http.StatusCreated, userFromDB(user))
+	}
+}
+
+// verifyEmailHandler marks the owner of a verification token as having a
+// verified email. Tokens can only be used once.
+func (cfg *apiConfig) verifyEmailHandler(db *sql.DB, queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var req VerifyRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		if req.Token == "" {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "Token is required")
+			return
+		}
+
+		var user database.User
+		err := withTx(ctx, db, func(tx *sql.Tx) error {
+			qtx := database.New(cfg.logSlowQueries(tx))
+			now := time.Now().UTC()
+
+			userID, err := qtx.GetUserFromVerificationToken(ctx, database.GetUserFromVerificationTokenParams{
+				TokenHash: hashToken(req.Token),
+				ExpiresAt: now,
+			})
+			if err != nil {
+				return err
+			}
+
+			user, err = qtx.VerifyUserEmail(ctx, database.VerifyUserEmailParams{
+				UpdatedAt: now,
+				ID:        userID,
+			})
+			if err != nil {
+				return err
+			}
+
+			return qtx.DeleteVerificationTokens(ctx, userID)
+		})
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidToken, "Invalid or expired token")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error verifying email", "error", err)
+			respondWithDBError(w, r, ctx, "Could not verify email")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, userFromDB(user))
+	}
+}
+
+// passwordResetHandler issues a password reset token for the given email.
+// It responds 204 whether or not the email belongs to a user, so it can't be
+// used to discover accounts.
+func (cfg *apiConfig) passwordResetHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var req PasswordResetRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		user, err := queries.GetUserByEmail(ctx, strings.ToLower(req.Email))
+		if err == sql.ErrNoRows {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not reset password")
+			return
+		}
+
+		token, err := makeRandomToken()
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating password reset token", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not reset password")
+			return
+		}
+
+		now := time.Now().UTC()
+		err = queries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+			TokenHash: hashToken(token),
+			CreatedAt: now,
+			UserID:    user.ID,
+			ExpiresAt: now.Add(passwordResetTokenTTL),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error saving password reset token", "error", err)
+			respondWithDBError(w, r, ctx, "Could not reset password")
+			return
+		}
+
+		// There is no mail delivery yet, so the token is only surfaced in
+		// dev where it can be read from the logs
+		if cfg.platform == "dev" {
+			slog.InfoContext(ctx, "Password reset token issued", "user_id", user.ID, "token", token)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// passwordResetConfirmHandler sets a new password for the owner of an
+// unused, unexpired reset token and marks the token used.
+func (cfg *apiConfig) passwordResetConfirmHandler(db *sql.DB, queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var req PasswordResetConfirmRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		if req.Token == "" {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "Token is required")
+			return
+		}
+		if req.Password == "" {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "Password is required")
+			return
+		}
+
+		hashedPassword, err := hashPassword(req.Password, cfg.bcryptCost)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error hashing password", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not reset password")
+			return
+		}
+
+		err = withTx(ctx, db, func(tx *sql.Tx) error {
+			qtx := database.New(cfg.logSlowQueries(tx))
+			now := time.Now().UTC()
+
+			userID, err := qtx.UsePasswordResetToken(ctx, database.UsePasswordResetTokenParams{
+				UsedAt:    sql.NullTime{Time: now, Valid: true},
+				TokenHash: hashToken(req.Token),
+				ExpiresAt: now,
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = qtx.PatchUser(ctx, database.PatchUserParams{
+				HashedPassword: sql.NullString{String: hashedPassword, Valid: true},
+				UpdatedAt:      now,
+				ID:             userID,
+			})
+			return err
+		})
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidToken, "Invalid or expired token")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error resetting password", "error", err)
+			respondWithDBError(w, r, ctx, "Could not reset password")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// requireVerified writes a 403 and returns false if REQUIRE_VERIFIED_EMAIL is
+// set and userID hasn't verified their email.
+func (cfg *apiConfig) requireVerified(w http.ResponseWriter, r *http.Request, ctx context.Context, queries *database.Queries, userID uuid.UUID) bool {
+	if !cfg.requireVerifiedEmail {
+		return true
+	}
+
+	user, err := queries.GetUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, http.StatusBadRequest, errCodeUnknownUser, "unknown user")
+		return false
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error fetching user", "error", err)
+		respondWithDBError(w, r, ctx, "Could not verify user")
+		return false
+	}
+
+	if !user.EmailVerified {
+		respondWithError(w, r, http.StatusForbidden, errCodeEmailNotVerified, "Email must be verified first")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) getUserByIDHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, err := uuid.Parse(r.PathValue("userID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid user id")
+			return
+		}
+
+		user, err := queries.GetUser(ctx, userID)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve user")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, userFromDB(user))
+	}
+}
+
+// followUserHandler makes the authenticated user follow another user.
+// Following someone twice is a no-op.
+func (cfg *apiConfig) followUserHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		followerID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		followeeID, err := uuid.Parse(r.PathValue("userID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid user id")
+			return
+		}
+
+		if followeeID == followerID {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "You cannot follow yourself")
+			return
+		}
+
+		err = queries.CreateFollow(ctx, database.CreateFollowParams{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+			CreatedAt:  time.Now().UTC(),
+		})
+		if isForeignKeyViolation(err) {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error following user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not follow user")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// unfollowUserHandler makes the authenticated user stop following another
+// user, if they were.
+func (cfg *apiConfig) unfollowUserHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		followerID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		followeeID, err := uuid.Parse(r.PathValue("userID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid user id")
+			return
+		}
+
+		if followeeID == followerID {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "You cannot follow yourself")
+			return
+		}
+
+		err = queries.DeleteFollow(ctx, database.DeleteFollowParams{
+			FollowerID: followerID,
+			FolloweeID: followeeID,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error unfollowing user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not unfollow user")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (cfg *apiConfig) getFollowersHandler(queries *database.Queries) http.HandlerFunc {
+	return cfg.followListHandler(queries, func(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]database.User, error) {
+		return queries.GetFollowers(ctx, database.GetFollowersParams{
+			FolloweeID: userID,
+			Limit:      limit,
+			Offset:     offset,
+		})
+	})
+}
+
+func (cfg *apiConfig) getFollowingHandler(queries *database.Queries) http.HandlerFunc {
+	return cfg.followListHandler(queries, func(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]database.User, error) {
+		return queries.GetFollowing(ctx, database.GetFollowingParams{
+			FollowerID: userID,
+			Limit:      limit,
+			Offset:     offset,
+		})
+	})
+}
+
+// followListHandler serves a paginated list of users related to the user in
+// the path, as fetched by list.
+func (cfg *apiConfig) followListHandler(queries *database.Queries, list func(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]database.User, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, err := uuid.Parse(r.PathValue("userID"))
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "invalid user id")
+			return
+		}
+
+		limit, offset, ok := parsePagination(w, r, defaultUsersLimit, maxUsersLimit)
+		if !ok {
+			return
+		}
+
+		if _, err := queries.GetUser(ctx, userID); err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve users")
+			return
+		}
+
+		dbUsers, err := list(ctx, userID, int32(limit), int32(offset))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching follows", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve users")
+			return
+		}
+
+		users := make([]User, 0, len(dbUsers))
+		for _, user := range dbUsers {
+			users = append(users, userFromDB(user))
+		}
+
+		w.Header().Set("X-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Offset", strconv.Itoa(offset))
+		respondWith(w, r, http.StatusOK, users)
+	}
+}
+
+func (cfg *apiConfig) meHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := queries.GetUser(ctx, userID)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve user")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, userFromDB(user))
+	}
+}
+
+func (cfg *apiConfig) getUsersHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		// Listing every user unauthenticated isn't allowed, so a filter is required
+		email := strings.ToLower(r.URL.Query().Get("email"))
+		if email == "" {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidParameter, "email query parameter is required")
+			return
+		}
+
+		user, err := queries.GetUserByEmail(ctx, email)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not retrieve user")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, userFromDB(user))
+	}
+}
+
+func (cfg *apiConfig) updateUserHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		var req UserRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			respondWithValidationError(w, r, err)
+			return
+		}
+		req.Email = strings.ToLower(req.Email)
+
+		hashedPassword, err := hashPassword(req.Password, cfg.bcryptCost)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error hashing password", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not update user")
+			return
+		}
+
+		user, err := queries.UpdateUser(ctx, database.UpdateUserParams{
+			Email:          req.Email,
+			HashedPassword: hashedPassword,
+			UpdatedAt:      time.Now().UTC(),
+			ID:             userID,
+		})
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if isUniqueViolation(err) {
+			respondWithError(w, r, http.StatusConflict, errCodeEmailTaken, "email already exists")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error updating user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not update user")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, userFromDB(user))
+	}
+}
+
+// patchUserHandler updates only the fields present in the request body.
+func (cfg *apiConfig) patchUserHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		var req UserPatchRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		params := database.PatchUserParams{
+			UpdatedAt: time.Now().UTC(),
+			ID:        userID,
+		}
+
+		if req.Email != nil {
+			email := strings.ToLower(*req.Email)
+			if err := validate.Email(email); err != nil {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "invalid email")
+				return
+			}
+			params.Email = sql.NullString{String: email, Valid: true}
+		}
+
+		if req.Password != nil {
+			if *req.Password == "" {
+				respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "Password cannot be empty")
+				return
+			}
+			hashedPassword, err := hashPassword(*req.Password, cfg.bcryptCost)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error hashing password", "error", err)
+				respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not update user")
+				return
+			}
+			params.HashedPassword = sql.NullString{String: hashedPassword, Valid: true}
+		}
+
+		user, err := queries.PatchUser(ctx, params)
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		} else if isUniqueViolation(err) {
+			respondWithError(w, r, http.StatusConflict, errCodeEmailTaken, "email already exists")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error updating user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not update user")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, userFromDB(user))
+	}
+}
+
+// deleteUserHandler deletes the authenticated user. Their chirps and refresh
+// tokens go with them via ON DELETE CASCADE.
+func (cfg *apiConfig) deleteUserHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		userID, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		if err := queries.DeleteUser(ctx, userID); err != nil {
+			slog.ErrorContext(ctx, "Error deleting user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not delete user")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (cfg *apiConfig) loginHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		var req LoginRequest
+		if !cfg.decodeJSON(w, r, &req) {
+			return
+		}
+
+		user, err := queries.GetUserByEmail(ctx, strings.ToLower(req.Email))
+		if err == sql.ErrNoRows {
+			// Still run bcrypt so response timing doesn't reveal which
+			// emails have accounts
+			checkPasswordHash(req.Password, cfg.dummyPasswordHash)
+			respondWithError(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, "Incorrect email or password")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not log in")
+			return
+		}
+
+		if err := checkPasswordHash(req.Password, user.HashedPassword); err != nil {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, "Incorrect email or password")
+			return
+		}
+
+		token, err := makeJWT(user.ID.String(), cfg.jwtSecret, cfg.jwtIssuer, cfg.jwtAudience, accessTokenTTL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating token", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not log in")
+			return
+		}
+
+		refreshToken, err := makeRandomToken()
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating refresh token", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not log in")
+			return
+		}
+
+		now := time.Now().UTC()
+		err = queries.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+			Token:     refreshToken,
+			CreatedAt: now,
+			UpdatedAt: now,
+			UserID:    user.ID,
+			ExpiresAt: now.Add(refreshTokenTTL),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error saving refresh token", "error", err)
+			respondWithDBError(w, r, ctx, "Could not log in")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, LoginResponse{
+			User:         userFromDB(user),
+			Token:        token,
+			RefreshToken: refreshToken,
+		})
+	}
+}
+
+func (cfg *apiConfig) refreshHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		refreshToken, err := getBearerToken(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Missing or malformed token")
+			return
+		}
+
+		userID, err := queries.GetUserFromRefreshToken(ctx, database.GetUserFromRefreshTokenParams{
+			Token:     refreshToken,
+			ExpiresAt: time.Now().UTC(),
+		})
+		if err == sql.ErrNoRows {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid or expired refresh token")
+			return
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error fetching refresh token", "error", err)
+			respondWithDBError(w, r, ctx, "Could not refresh token")
+			return
+		}
+
+		token, err := makeJWT(userID.String(), cfg.jwtSecret, cfg.jwtIssuer, cfg.jwtAudience, accessTokenTTL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating token", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Could not refresh token")
+			return
+		}
+
+		respondWith(w, r, http.StatusOK, TokenResponse{Token: token})
+	}
+}
+
+func (cfg *apiConfig) revokeHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		refreshToken, err := getBearerToken(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Missing or malformed token")
+			return
+		}
+
+		now := time.Now().UTC()
+		err = queries.RevokeRefreshToken(ctx, database.RevokeRefreshTokenParams{
+			RevokedAt: sql.NullTime{Time: now, Valid: true},
+			UpdatedAt: now,
+			Token:     refreshToken,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error revoking refresh token", "error", err)
+			respondWithDBError(w, r, ctx, "Could not revoke token")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (cfg *apiConfig) polkaWebhookHandler(queries *database.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.queryTimeout)
+		defer cancel()
+
+		apiKey, err := getAPIKey(r)
+		if err != nil || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaKey)) != 1 {
+			respondWithError(w, r, http.StatusUnauthorized, errCodeUnauthorized, "Invalid API key")
+			return
+		}
+
+		var req PolkaWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeMalformedBody, "Invalid request payload")
+			return
+		}
+
+		if req.Event != "user.upgraded" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		userID, err := uuid.Parse(req.Data.UserID)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, errCodeInvalidRequest, "Invalid user_id")
+			return
+		}
+
+		rows, err := queries.UpgradeUserToChirpyRed(ctx, database.UpgradeUserToChirpyRedParams{
+			UpdatedAt: time.Now().UTC(),
+			ID:        userID,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error upgrading user", "error", err)
+			respondWithDBError(w, r, ctx, "Could not upgrade user")
+			return
+		}
+
+		if rows == 0 {
+			respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "User not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// readinessHandler reports whether the service can reach its database, unlike
+// healthHandler which only reports that the process is up.
+func readinessHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := db.PingContext(ctx); err != nil {
+			slog.ErrorContext(ctx, "Readiness check failed", "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("database unavailable"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// routes registers every handler on a new mux, under cfg.basePath.
+func (cfg *apiConfig) routes(db *sql.DB, dbQueries *database.Queries, chirpLimiter *rateLimiter, streamMaxConns int, assetsDir, welcomeMessage string) *http.ServeMux {
+	// Routes are registered under BASE_PATH, so the mux's own redirects and
+	// 404s already account for it
+	serveMux := http.NewServeMux()
+	mux := prefixMux{ServeMux: serveMux, prefix: cfg.basePath}
+
+	// Static file server with metrics
+	fileServer := http.FileServer(http.Dir(assetsDir))
+	mux.Handle("/app/assets/", cfg.middlewareMetricsInc(http.StripPrefix(cfg.basePath+"/app/assets/", fileServer)))
+
+	// API routes
+	mux.HandleFunc("GET /api/healthz", healthHandler)
+	mux.HandleFunc("GET /api/readyz", readinessHandler(db))
+	mux.HandleFunc("GET /api/openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /api/chirps", cfg.getChirpHandler(dbQueries))
+	mux.HandleFunc("GET "+chirpStreamPath, cfg.chirpStreamHandler(streamMaxConns))
+	mux.HandleFunc("GET "+chirpEventsPath, cfg.chirpEventsHandler(streamMaxConns))
+	mux.HandleFunc("GET /api/chirps/count", cfg.countChirpsHandler(dbQueries))
+	mux.HandleFunc("GET /api/chirps/today", cfg.getTodayChirpsHandler(dbQueries))
+	mux.HandleFunc("POST /api/chirps/lookup", cfg.lookupChirpsHandler(dbQueries))
+	mux.HandleFunc("POST /api/chirps/validate", cfg.validateChirpHandler)
+	mux.HandleFunc("GET /api/chirps/{chirpID}", cfg.getChirpByIDHandler(dbQueries))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.updateChirpHandler(dbQueries))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.deleteChirpHandler(dbQueries))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/replies", cfg.getChirpRepliesHandler(dbQueries))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", cfg.likeChirpHandler(dbQueries))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", cfg.unlikeChirpHandler(dbQueries))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/pin", cfg.pinChirpHandler(dbQueries, true))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/pin", cfg.pinChirpHandler(dbQueries, false))
+	mux.HandleFunc("POST /api/users", cfg.createUserHandler(db, dbQueries))
+	mux.HandleFunc("GET /api/users", cfg.getUsersHandler(dbQueries))
+	mux.HandleFunc("GET /api/users/{userID}", cfg.getUserByIDHandler(dbQueries))
+	mux.HandleFunc("POST /api/users/{userID}/follow", cfg.followUserHandler(dbQueries))
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.unfollowUserHandler(dbQueries))
+	mux.HandleFunc("GET /api/users/{userID}/followers", cfg.getFollowersHandler(dbQueries))
+	mux.HandleFunc("GET /api/users/{userID}/following", cfg.getFollowingHandler(dbQueries))
+	mux.HandleFunc("PUT /api/users", cfg.updateUserHandler(dbQueries))
+	mux.HandleFunc("PATCH /api/users", cfg.patchUserHandler(dbQueries))
+	mux.HandleFunc("DELETE /api/users", cfg.deleteUserHandler(dbQueries))
+	mux.HandleFunc("GET /api/feed", cfg.feedHandler(dbQueries))
+	mux.HandleFunc("GET /api/me", cfg.meHandler(dbQueries))
+	mux.HandleFunc("POST /api/verify", cfg.verifyEmailHandler(db, dbQueries))
+	mux.HandleFunc("POST /api/password-reset", cfg.passwordResetHandler(dbQueries))
+	mux.HandleFunc("POST /api/password-reset/confirm", cfg.passwordResetConfirmHandler(db, dbQueries))
+	mux.HandleFunc("POST /api/login", cfg.loginHandler(dbQueries))
+	mux.HandleFunc("POST /api/refresh", cfg.refreshHandler(dbQueries))
+	mux.HandleFunc("POST /api/revoke", cfg.revokeHandler(dbQueries))
+	mux.HandleFunc("POST /api/polka/webhooks", cfg.polkaWebhookHandler(dbQueries))
+	mux.Handle("POST /api/chirps", chirpLimiter.middleware(cfg.createChirpHandler(db, dbQueries)))
+	mux.HandleFunc("POST /api/chirps/batch", cfg.createChirpsBatchHandler(db, dbQueries, chirpLimiter))
+
+	// Admin routes
+	mux.HandleFunc("GET /admin/metrics", cfg.metricsHandler(dbQueries))
+	mux.HandleFunc("POST /admin/reset", cfg.resetHandler(db))
+	mux.HandleFunc("POST /admin/metrics/reset", cfg.resetMetricsHandler)
+	mux.HandleFunc("GET /admin/users", cfg.adminUsersHandler(dbQueries))
+	mux.HandleFunc("POST /admin/users/{userID}/logout", cfg.adminLogoutUserHandler(dbQueries))
+	mux.HandleFunc("DELETE /admin/chirps", cfg.adminDeleteChirpsHandler(dbQueries))
+	mux.HandleFunc("GET /admin/maintenance", cfg.maintenanceHandler)
+	mux.HandleFunc("PUT /admin/maintenance", cfg.setMaintenanceHandler)
+
+	// Prometheus scrape endpoint
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	// Welcome route
+	// A WELCOME_MESSAGE starting with "<" is served as HTML
+	welcomeType := "text/plain; charset=utf-8"
+	if strings.HasPrefix(strings.TrimSpace(welcomeMessage), "<") {
+		welcomeType = "text/html; charset=utf-8"
+	}
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", welcomeType)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(welcomeMessage))
+	})
+
+	// Unknown API routes get a JSON 404 instead of the mux's plain text one
+	mux.HandleFunc("/api/", apiNotFoundHandler(serveMux, cfg.basePath+"/api/"))
+
+	return serveMux
+}
+
+func main() {
+	apiCfg := apiConfig{}
+
+	// .env is a local convenience; deployments usually set the environment
+	// directly (or mount *_FILE secrets) and have none
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
+	}
+
+	// Text is easier to read locally; elsewhere logs are usually collected
+	// and parsed, so JSON stays the default
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat == "" {
+		logFormat = "json"
+		if os.Getenv("PLATFORM") == "dev" {
+			logFormat = "text"
+		}
+	}
+	logger, err := newLogger(os.Getenv("LOG_LEVEL"), logFormat)
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	dbURL := envSecret("DB_URL")
+	if dbURL == "" {
+		logFatal("DB_URL or DB_URL_FILE environment variable is not set")
+	}
+	apiCfg.jwtSecret = envSecret("JWT_SECRET")
+	if apiCfg.jwtSecret == "" {
+		logFatal("JWT_SECRET or JWT_SECRET_FILE environment variable is not set")
+	}
+	apiCfg.jwtIssuer = os.Getenv("JWT_ISSUER")
+	if apiCfg.jwtIssuer == "" {
+		apiCfg.jwtIssuer = defaultJWTIssuer
+	}
+	apiCfg.jwtAudience = os.Getenv("JWT_AUDIENCE")
+	if apiCfg.jwtAudience == "" {
+		apiCfg.jwtAudience = defaultJWTAudience
+	}
+	apiCfg.polkaKey = envSecret("POLKA_KEY")
+	if apiCfg.polkaKey == "" {
+		logFatal("POLKA_KEY or POLKA_KEY_FILE environment variable is not set")
+	}
+	apiCfg.platform = os.Getenv("PLATFORM")
+
+	// BASE_PATH mounts every route under a prefix, for proxies that forward
+	// a subpath without stripping it
+	apiCfg.basePath = strings.TrimSuffix(os.Getenv("BASE_PATH"), "/")
+	if apiCfg.basePath != "" && !strings.HasPrefix(apiCfg.basePath, "/") {
+		logFatal("BASE_PATH must start with /", "base_path", apiCfg.basePath)
+	}
+
+	// An explicitly empty PROFANE_WORDS disables censoring
+	apiCfg.profaneWords = defaultProfaneWords
+	if words, ok := os.LookupEnv("PROFANE_WORDS"); ok {
+		apiCfg.profaneWords = nil
+		for _, word := range strings.Split(words, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				apiCfg.profaneWords = append(apiCfg.profaneWords, word)
+			}
+		}
+	}
+	apiCfg.profaneReplacement = os.Getenv("PROFANE_REPLACEMENT")
+	if apiCfg.profaneReplacement == "" {
+		apiCfg.profaneReplacement = defaultProfaneReplacement
+	}
+
+	// 0 turns slow query logging off
+	apiCfg.slowQueryThreshold = envDuration("SLOW_QUERY_THRESHOLD", 200*time.Millisecond)
+	if apiCfg.slowQueryThreshold < 0 {
+		logFatal("SLOW_QUERY_THRESHOLD must not be negative")
+	}
+
+	apiCfg.queryTimeout = envDuration("DB_QUERY_TIMEOUT", 5*time.Second)
+	if apiCfg.queryTimeout <= 0 {
+		logFatal("DB_QUERY_TIMEOUT must be positive")
+	}
+
+	apiCfg.maxBodyBytes = int64(envInt("MAX_BODY_BYTES", 1<<20))
+	if apiCfg.maxBodyBytes <= 0 {
+		logFatal("MAX_BODY_BYTES must be positive")
+	}
+
+	apiCfg.softDelete = envBool("SOFT_DELETE_CHIRPS", false)
+	apiCfg.requireVerifiedEmail = envBool("REQUIRE_VERIFIED_EMAIL", false)
+	apiCfg.maintenance.Store(envBool("MAINTENANCE_MODE", false))
+	apiCfg.adminKey = os.Getenv("ADMIN_API_KEY")
+
+	apiCfg.chirpMaxLength = envInt("CHIRP_MAX_LENGTH", 140)
+	if apiCfg.chirpMaxLength < 1 {
+		logFatal("CHIRP_MAX_LENGTH must be positive")
+	}
+
+	// 0 leaves the number of chirps per user unlimited
+	apiCfg.maxChirpsPerUser = envInt("MAX_CHIRPS_PER_USER", 0)
+	if apiCfg.maxChirpsPerUser < 0 {
+		logFatal("MAX_CHIRPS_PER_USER must not be negative")
+	}
+
+	apiCfg.bcryptCost = bcrypt.DefaultCost
+	if costStr := os.Getenv("BCRYPT_COST"); costStr != "" {
+		cost, err := strconv.Atoi(costStr)
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			logFatal("BCRYPT_COST must be an integer in range", "min", bcrypt.MinCost, "max", bcrypt.MaxCost, "value", costStr)
+		}
+		apiCfg.bcryptCost = cost
+	}
+
+	// Logins for unknown emails check against this hash so they take as
+	// long as logins for real ones
+	apiCfg.dummyPasswordHash, err = hashPassword("not-a-real-password", apiCfg.bcryptCost)
+	if err != nil {
+		logFatal("Failed to hash dummy password", "error", err)
+	}
+
+	chirpRateLimit := envInt("CHIRP_RATE_LIMIT", 5)
+	chirpRateWindow := envDuration("CHIRP_RATE_WINDOW", time.Minute)
+	if chirpRateLimit < 1 || chirpRateWindow <= 0 {
+		logFatal("CHIRP_RATE_LIMIT and CHIRP_RATE_WINDOW must be positive")
+	}
+
+	// Without TRUSTED_PROXIES, forwarding headers are ignored and the
+	// connection's address is the client's
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		logFatal("Invalid TRUSTED_PROXIES", "error", err)
+	}
+
+	requestTimeout := envDuration("REQUEST_TIMEOUT", 10*time.Second)
+	if requestTimeout <= 0 {
+		logFatal("REQUEST_TIMEOUT must be positive")
+	}
+
+	apiCfg.broker = newChirpBroker()
+	streamMaxConns := envInt("STREAM_MAX_CONNS", 100)
+	if streamMaxConns <= 0 {
+		logFatal("STREAM_MAX_CONNS must be positive")
+	}
+
+	assetsDir := os.Getenv("ASSETS_DIR")
+	if assetsDir == "" {
+		assetsDir = "./assets"
+	}
+	if info, err := os.Stat(assetsDir); err != nil || !info.IsDir() {
+		logFatal("ASSETS_DIR must be an existing directory", "value", assetsDir)
+	}
+
+	corsOrigins := []string{"*"}
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		corsOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				corsOrigins = append(corsOrigins, origin)
+			}
+		}
+	}
+
+	gzipMinSize := envInt("GZIP_MIN_SIZE", 1024)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		logFatal("PORT must be an integer between 1 and 65535", "value", port)
+	}
+	addr := net.JoinHostPort(os.Getenv("HOST"), port)
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		logFatal("Failed to connect to database", "error", err)
+	}
+	defer db.Close()
+
+	maxOpenConns := envInt("DB_MAX_OPEN_CONNS", 25)
+	maxIdleConns := envInt("DB_MAX_IDLE_CONNS", 25)
+	connMaxLifetime := envDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute)
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+	slog.Info("Database pool configured",
+		"max_open_conns", maxOpenConns,
+		"max_idle_conns", maxIdleConns,
+		"conn_max_lifetime", connMaxLifetime,
+	)
+
+	// Postgres may still be starting (e.g. under Docker Compose), so retry
+	// the initial ping with backoff before giving up
+	pingAttempts := envInt("DB_PING_ATTEMPTS", 10)
+	if pingAttempts <= 0 {
+		logFatal("DB_PING_ATTEMPTS must be positive")
+	}
+	if err := pingWithRetry(db, pingAttempts); err != nil {
+		logFatal("Failed to ping database", "error", err, "attempts", pingAttempts)
+	}
+
+	// `go_chirpy migrate [up|down|status]` manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		command := "up"
+		if len(os.Args) > 2 {
+			command = os.Args[2]
+		}
+		if err := runMigrations(context.Background(), db, command); err != nil {
+			logFatal("Failed to run migrations", "error", err)
+		}
+		return
+	}
+
+	// SEED_DATA fills an empty dev database with sample users and chirps
+	if envBool("SEED_DATA", false) {
+		if apiCfg.platform != "dev" {
+			slog.Warn("Ignoring SEED_DATA outside the dev platform")
+		} else if err := apiCfg.seedData(context.Background(), db); err != nil {
+			logFatal("Failed to seed data", "error", err)
+		}
+	}
+
+	dbQueries := database.New(apiCfg.logSlowQueries(db))
+
+	// Stopped once the server has shut down, before the deferred db.Close
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go purgeIdempotencyKeys(purgeCtx, dbQueries, idempotencyPurgeInterval)
+
+	welcomeMessage := os.Getenv("WELCOME_MESSAGE")
+	if welcomeMessage == "" {
+		welcomeMessage = defaultWelcomeMessage
+	}
+
+	chirpLimiter := newRateLimiter(chirpRateLimit, chirpRateWindow)
+	serveMux := apiCfg.routes(db, dbQueries, chirpLimiter, streamMaxConns, assetsDir, welcomeMessage)
+
+	var skipTimeout []string
+	for _, path := range streamPaths {
+		skipTimeout = append(skipTimeout, apiCfg.basePath+path)
+	}
+
+	// Middleware, innermost first
+	var handler http.Handler = serveMux
+	handler = timeoutMiddleware(requestTimeout, skipTimeout, handler)
+	handler = maintenanceMiddleware(&apiCfg.maintenance, apiCfg.basePath, handler)
+	handler = methodNotAllowedMiddleware(handler)
+	handler = corsMiddleware(corsOrigins, handler)
+	handler = recoverMiddleware(handler)
+	handler = gzipMiddleware(gzipMinSize, handler)
+	handler = loggingMiddleware(handler)
+	handler = clientIPMiddleware(trustedProxies, handler)
+	handler = requestIDMiddleware(handler)
+
+	// A short header timeout and a bounded idle timeout keep slow or
+	// abandoned clients from holding connections open indefinitely
+	readTimeout := envDuration("SERVER_READ_TIMEOUT", 15*time.Second)
+	writeTimeout := envDuration("SERVER_WRITE_TIMEOUT", 15*time.Second)
+	idleTimeout := envDuration("SERVER_IDLE_TIMEOUT", 60*time.Second)
+	readHeaderTimeout := envDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second)
+	if readTimeout <= 0 || writeTimeout <= 0 || idleTimeout <= 0 || readHeaderTimeout <= 0 {
+		logFatal("SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT, SERVER_IDLE_TIMEOUT and SERVER_READ_HEADER_TIMEOUT must be positive")
+	}
+
+	// With a certificate the server speaks TLS, and net/http negotiates
+	// HTTP/2 over it automatically; without one it stays plaintext HTTP/1.1
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		logFatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	useTLS := tlsCertFile != ""
+
+	// Start server
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	// Shutdown doesn't cancel request contexts, so open streams are ended
+	// through the broker instead of holding it up until shutdownTimeout
+	srv.RegisterOnShutdown(apiCfg.broker.close)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting",
+			"addr", addr,
+			"read_timeout", readTimeout,
+			"write_timeout", writeTimeout,
+			"idle_timeout", idleTimeout,
+			"read_header_timeout", readHeaderTimeout,
+			"tls", useTLS,
+			"base_path", apiCfg.basePath,
+		)
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		logFatal("Failed to start server", "error", err)
+	case <-stop:
+	}
+
+	// Let in-flight requests drain before the deferred db.Close runs
+	slog.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	log.Printf("Server starting on :8080")
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Error during shutdown", "error", err)
 	}
 }
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestFromDBTimestampsAreUTC(t *testing.T) {
+	zone := time.FixedZone("UTC+5:30", 5*60*60+30*60)
+	created := time.Date(2024, time.March, 1, 9, 30, 0, 0, zone)
+	updated := created.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		payload interface{}
+	}{
+		{"chirp", chirpFromDB(database.Chirp{CreatedAt: created, UpdatedAt: updated})},
+		{"user", userFromDB(database.User{CreatedAt: created, UpdatedAt: updated})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.payload)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+
+			var got struct {
+				CreatedAt string `json:"created_at"`
+				UpdatedAt string `json:"updated_at"`
+			}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+
+			for field, value := range map[string]string{"created_at": got.CreatedAt, "updated_at": got.UpdatedAt} {
+				if !strings.HasSuffix(value, "Z") {
+					t.Errorf("%s = %q, want a UTC timestamp ending in Z", field, value)
+				}
+			}
+			if got.CreatedAt != "2024-03-01T04:00:00Z" {
+				t.Errorf("created_at = %q, want 2024-03-01T04:00:00Z", got.CreatedAt)
+			}
+		})
+	}
+}
+
+// getChirpColumns are the columns GetChirp selects, in order.
+var getChirpColumns = []string{
+	"id", "created_at", "updated_at", "body", "user_id", "deleted_at",
+	"parent_id", "timezone", "is_pinned", "like_count", "author_email",
+}
+
+func TestGetChirpByIDHandler(t *testing.T) {
+	found := uuid.MustParse("11111111-1111-4111-8111-111111111111")
+	author := uuid.MustParse("22222222-2222-4222-8222-222222222222")
+	created := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	db := newStubDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if len(args) == 1 && args[0].Value == found.String() {
+			return getChirpColumns, [][]driver.Value{{
+				found.String(), created, created, "hello", author.String(), nil,
+				nil, nil, false, int64(3), "author@example.com",
+			}}, nil
+		}
+		return getChirpColumns, nil, nil
+	})
+	cfg := &apiConfig{queryTimeout: time.Second}
+	handler := cfg.getChirpByIDHandler(database.New(db))
+
+	tests := []struct {
+		name       string
+		chirpID    string
+		wantStatus int
+		wantCode   string
+	}{
+		{"malformed", "not-a-uuid", http.StatusBadRequest, errCodeInvalidParameter},
+		{"missing", uuid.NewString(), http.StatusNotFound, errCodeNotFound},
+		{"found", found.String(), http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/chirps/"+tt.chirpID, nil)
+			r.SetPathValue("chirpID", tt.chirpID)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantCode != "" {
+				var body struct {
+					Code string `json:"code"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("decode error body: %v", err)
+				}
+				if body.Code != tt.wantCode {
+					t.Errorf("code = %q, want %q", body.Code, tt.wantCode)
+				}
+				return
+			}
+
+			var chirp Chirp
+			if err := json.Unmarshal(w.Body.Bytes(), &chirp); err != nil {
+				t.Fatalf("decode chirp: %v", err)
+			}
+			if chirp.ID != found || chirp.UserID != author || chirp.Body != "hello" || chirp.LikeCount == nil || *chirp.LikeCount != 3 {
+				t.Errorf("chirp = %+v, want id %s by %s with body hello and 3 likes", chirp, found, author)
+			}
+		})
+	}
+}
+
+func TestCleanChirpBodyPunctuation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"trailing punctuation", "Sharbert!", "****!"},
+		{"surrounding punctuation", "I had a (kerfuffle), sorry", "I had a (****), sorry"},
+		{"quoted", `they said "fornax"`, `they said "****"`},
+		{"leading punctuation", "...kerfuffle", "...****"},
+		{"punctuation only", "?! ...", "?! ..."},
+		// Only the ends of a token are trimmed, so a hyphenated compound is
+		// a single word that doesn't match any profane word
+		{"hyphen compound", "kerfuffle-sharbert", "kerfuffle-sharbert"},
+		{"clean", "hello world", "hello world"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanChirpBody(tt.body, defaultProfaneWords, defaultProfaneReplacement); got != tt.want {
+				t.Errorf("cleanChirpBody(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlowQueryReturns504(t *testing.T) {
+	db := openTestDB(t)
+	cfg := &apiConfig{queryTimeout: 50 * time.Millisecond}
+	handler := cfg.getChirpByIDHandler(database.New(sleepyDB{db}))
+
+	chirpID := uuid.NewString()
+	r := httptest.NewRequest(http.MethodGet, "/api/chirps/"+chirpID, nil)
+	r.SetPathValue("chirpID", chirpID)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusGatewayTimeout, w.Body)
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Code != errCodeTimeout {
+		t.Errorf("code = %q, want %q", body.Code, errCodeTimeout)
+	}
+}
+
+func TestValidateChirpCountsRunes(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"140 accented letters", strings.Repeat("é", 140), false},
+		{"140 emoji", strings.Repeat("🐦", 140), false},
+		{"141 accented letters", strings.Repeat("é", 141), true},
+		{"141 emoji", strings.Repeat("🐦", 141), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateChirp(tt.body, 140)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateChirp(%d bytes) error = %v, want error %v", len(tt.body), err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteUserRemovesChirps(t *testing.T) {
+	db := openTestDB(t)
+	queries := database.New(db)
+	ctx := context.Background()
+	cfg := &apiConfig{
+		queryTimeout: 5 * time.Second,
+		jwtSecret:    "test-secret",
+		jwtIssuer:    defaultJWTIssuer,
+		jwtAudience:  defaultJWTAudience,
+	}
+	handler := cfg.deleteUserHandler(queries)
+
+	now := time.Now().UTC()
+	user, err := queries.CreateUser(ctx, database.CreateUserParams{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          uuid.NewString() + "@example.com",
+		HashedPassword: "unused",
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	chirp, err := queries.CreateChirp(ctx, database.CreateChirpParams{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Body:      "about to be deleted",
+		UserID:    user.ID,
+	})
+	if err != nil {
+		t.Fatalf("create chirp: %v", err)
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodDelete, "/api/users", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	token, err := makeJWT(user.ID.String(), cfg.jwtSecret, cfg.jwtIssuer, cfg.jwtAudience, time.Minute)
+	if err != nil {
+		t.Fatalf("make token: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodDelete, "/api/users", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusNoContent, w.Body)
+	}
+	if _, err := queries.GetUser(ctx, user.ID); err != sql.ErrNoRows {
+		t.Errorf("GetUser after delete: err = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := queries.GetChirp(ctx, chirp.ID); err != sql.ErrNoRows {
+		t.Errorf("GetChirp after delete: err = %v, want sql.ErrNoRows", err)
+	}
+	count, err := queries.CountChirps(ctx, database.CountChirpsParams{
+		AuthorID: uuid.NullUUID{UUID: user.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("count chirps: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("user still has %d chirps after delete", count)
+	}
+}
+
+func TestCleanChirpBodyCustomReplacement(t *testing.T) {
+	got := cleanChirpBody("what a Kerfuffle, truly", defaultProfaneWords, "[redacted]")
+	if want := "what a [redacted], truly"; got != want {
+		t.Errorf("cleanChirpBody = %q, want %q", got, want)
+	}
+}
+
+// cleanChirpBody matches with strings.EqualFold, which applies Unicode
+// simple case folding: one rune folds to one rune, with no locale rules.
+func TestCleanChirpBodyCaseFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+		body  string
+		want  string
+	}{
+		{"upper case", defaultProfaneWords, "KERFUFFLE", "****"},
+		{"mixed case", defaultProfaneWords, "ShArBeRt", "****"},
+		{"Kelvin sign folds to k", defaultProfaneWords, "Kerfuffle", "****"},
+		{"long s folds to s", defaultProfaneWords, "ſharbert", "****"},
+		{"ASCII I matches i", []string{"fix"}, "FIX", "****"},
+		// Turkish İ and ı only fold to i and I under Turkish locale rules,
+		// which EqualFold doesn't apply, so they don't match
+		{"Turkish dotted capital I", []string{"fix"}, "FİX", "FİX"},
+		{"Turkish dotless i", []string{"fix"}, "fıx", "fıx"},
+		// ß would need full case folding to become "ss"
+		{"sharp s is not ss", []string{"kiss"}, "kiß", "kiß"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanChirpBody(tt.body, tt.words, defaultProfaneReplacement); got != tt.want {
+				t.Errorf("cleanChirpBody(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateChirpHandlerTrimsBody(t *testing.T) {
+	cfg := &apiConfig{
+		chirpMaxLength:     5,
+		maxBodyBytes:       1 << 20,
+		profaneWords:       defaultProfaneWords,
+		profaneReplacement: defaultProfaneReplacement,
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantCleaned string
+		wantCode    string
+	}{
+		{"padded", `{"body":"  hi \n\t"}`, http.StatusOK, "hi", ""},
+		// Seven characters before trimming, but within the limit of five after
+		{"padding doesn't count toward the limit", `{"body":"  hello  "}`, http.StatusOK, "hello", ""},
+		{"whitespace only", `{"body":"   \t\n "}`, http.StatusBadRequest, "", errCodeValidationFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/chirps/validate", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			cfg.validateChirpHandler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			var resp struct {
+				CleanedBody string `json:"cleaned_body"`
+				Code        string `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if resp.CleanedBody != tt.wantCleaned {
+				t.Errorf("cleaned_body = %q, want %q", resp.CleanedBody, tt.wantCleaned)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestChirpXMLParentID(t *testing.T) {
+	parent := uuid.MustParse("33333333-3333-4333-8333-333333333333")
+
+	tests := []struct {
+		name     string
+		parentID uuid.NullUUID
+		want     string
+		notWant  string
+	}{
+		{"top-level", uuid.NullUUID{}, "", "parent_id"},
+		{"reply", uuid.NullUUID{UUID: parent, Valid: true}, "<parent_id>" + parent.String() + "</parent_id>", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := xml.Marshal(Chirp{Body: "hi", ParentID: tt.parentID})
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			got := string(data)
+			if !strings.HasPrefix(got, "<chirp>") {
+				t.Errorf("XML = %s, want a <chirp> element", got)
+			}
+			if tt.want != "" && !strings.Contains(got, tt.want) {
+				t.Errorf("XML = %s, want it to contain %s", got, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+				t.Errorf("XML = %s, want no %s", got, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailStoresTokenHashed(t *testing.T) {
+	db := openTestDB(t)
+	queries := database.New(db)
+	ctx := context.Background()
+	cfg := &apiConfig{queryTimeout: 5 * time.Second, maxBodyBytes: 1 << 20}
+	handler := cfg.verifyEmailHandler(db, queries)
+
+	now := time.Now().UTC()
+	user, err := queries.CreateUser(ctx, database.CreateUserParams{
+		ID:             uuid.New(),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Email:          uuid.NewString() + "@example.com",
+		HashedPassword: "unused",
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	const token = "plaintext-verification-token"
+	if err := queries.CreateVerificationToken(ctx, database.CreateVerificationTokenParams{
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		UserID:    user.ID,
+		ExpiresAt: now.Add(verificationTokenTTL),
+	}); err != nil {
+		t.Fatalf("create verification token: %v", err)
+	}
+
+	verify := func(token string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/verify", strings.NewReader(`{"token":"`+token+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// The stored digest must not itself work as a token
+	if got := verify(hashToken(token)); got != http.StatusBadRequest {
+		t.Errorf("verify with digest: status = %d, want %d", got, http.StatusBadRequest)
+	}
+	if got := verify(token); got != http.StatusOK {
+		t.Errorf("verify with token: status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestRoutesRegister(t *testing.T) {
+	db := newStubDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return nil, nil, nil
+	})
+
+	for _, basePath := range []string{"", "/v1"} {
+		t.Run("base path "+basePath, func(t *testing.T) {
+			cfg := &apiConfig{
+				queryTimeout: time.Second,
+				basePath:     basePath,
+				broker:       newChirpBroker(),
+			}
+			// Conflicting patterns make the mux panic here
+			mux := cfg.routes(db, database.New(db), newRateLimiter(5, time.Minute), 1, t.TempDir(), defaultWelcomeMessage)
+
+			// HEAD is answered by the GET route
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodHead, basePath+"/api/chirps/not-a-uuid", nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("HEAD status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCreateChirpsBatchRespectsRateLimit(t *testing.T) {
+	db := newStubDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return nil, nil, nil
+	})
+	cfg := &apiConfig{
+		queryTimeout:   time.Second,
+		maxBodyBytes:   1 << 20,
+		chirpMaxLength: 140,
+		jwtSecret:      testJWTSecret,
+		jwtIssuer:      defaultJWTIssuer,
+		jwtAudience:    defaultJWTAudience,
+	}
+	limiter := newRateLimiter(3, time.Minute)
+	handler := cfg.createChirpsBatchHandler(db, database.New(db), limiter)
+
+	token, err := makeJWT(uuid.NewString(), testJWTSecret, defaultJWTIssuer, defaultJWTAudience, time.Minute)
+	if err != nil {
+		t.Fatalf("makeJWT: %v", err)
+	}
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/chirps/batch", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// Bigger than the limiter's burst, so it could never be allowed
+	w := post(`[{"body":"a"},{"body":"b"},{"body":"c"},{"body":"d"}]`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("oversized batch: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(w.Body.String(), "more than 3 chirps") {
+		t.Errorf("oversized batch: body = %s, want the limit of 3", w.Body)
+	}
+
+	// Invalid batches are rejected before any tokens are taken
+	for range 3 {
+		if w := post(`[{"body":"a"},{"body":"b"},{"body":""}]`); w.Code != http.StatusBadRequest {
+			t.Fatalf("invalid batch: status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	}
+	if ok, _ := limiter.allowN("192.0.2.1", 3); !ok {
+		t.Error("invalid batches used up rate limit tokens")
+	}
+}
+
+func TestGetChirpsOnlyFetchesExpansionsWhenAsked(t *testing.T) {
+	var got []driver.NamedValue
+	db := newStubDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		got = args
+		return []string{"id"}, nil, nil
+	})
+	cfg := &apiConfig{queryTimeout: time.Second}
+	handler := cfg.getChirpHandler(database.New(db))
+
+	tests := []struct {
+		query string
+		want  [3]bool // expand likes, expand replies, include author
+	}{
+		{"", [3]bool{false, false, false}},
+		{"?expand=likes", [3]bool{true, false, false}},
+		{"?expand=likes,replies&include=author", [3]bool{true, true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/api/chirps"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusOK, w.Body)
+			}
+			for i, want := range tt.want {
+				if got[i].Value != want {
+					t.Errorf("arg %d = %v, want %v", i+1, got[i].Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMalformedUserIDIsBadRequest(t *testing.T) {
+	db := newStubDB(t, func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return nil, nil, nil
+	})
+	cfg := &apiConfig{
+		platform:     "dev",
+		queryTimeout: time.Second,
+		jwtSecret:    testJWTSecret,
+		jwtIssuer:    defaultJWTIssuer,
+		jwtAudience:  defaultJWTAudience,
+		broker:       newChirpBroker(),
+	}
+	mux := cfg.routes(db, database.New(db), newRateLimiter(5, time.Minute), 1, t.TempDir(), defaultWelcomeMessage)
+
+	token, err := makeJWT(uuid.NewString(), testJWTSecret, defaultJWTIssuer, defaultJWTAudience, time.Minute)
+	if err != nil {
+		t.Fatalf("makeJWT: %v", err)
+	}
+
+	for _, route := range []string{
+		"GET /api/users/not-a-uuid",
+		"POST /api/users/not-a-uuid/follow",
+		"DELETE /api/users/not-a-uuid/follow",
+		"GET /api/users/not-a-uuid/followers",
+		"GET /api/users/not-a-uuid/following",
+		"POST /admin/users/not-a-uuid/logout",
+	} {
+		t.Run(route, func(t *testing.T) {
+			method, path, _ := strings.Cut(route, " ")
+			r := httptest.NewRequest(method, path, nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d; body %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			var body struct {
+				Code string `json:"code"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body.Code != errCodeInvalidParameter {
+				t.Errorf("code = %q, want %q", body.Code, errCodeInvalidParameter)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// recoverMiddleware turns a panic in next into a logged 500 response instead
+// of letting it take down the connection.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			slog.ErrorContext(r.Context(), "Recovered from panic",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", rec,
+				"stack", string(debug.Stack()),
+			)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsMiddleware allows browsers on allowedOrigins to call the API. An entry
+// of "*" allows any origin.
+func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	allowAll := slices.Contains(allowedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if allowAll {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin != "" && slices.Contains(allowedOrigins, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeoutMiddleware gives each request timeout to finish before answering
+// with a JSON 503. Paths in skip, such as long-lived streams, are exempt,
+// since http.TimeoutHandler would cut them off and doesn't support flushing
+// or hijacking.
+func timeoutMiddleware(timeout time.Duration, skip []string, next http.Handler) http.Handler {
+	timeoutHandler := http.TimeoutHandler(next, timeout, `{"error":"Request timed out","code":"`+errCodeTimeout+`"}`)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(skip, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeoutHandler.ServeHTTP(&timeoutResponseWriter{ResponseWriter: w}, r)
+	})
+}
+
+// timeoutResponseWriter labels http.TimeoutHandler's 503 body as JSON, which
+// it otherwise sends without a Content-Type.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *timeoutResponseWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// maintenanceMiddleware answers /api/ requests under basePath with a 503
+// while enabled is set, leaving /api/healthz up so the process isn't
+// restarted mid-drain. Static and admin routes are unaffected, so
+// maintenance can be turned off again.
+func maintenanceMiddleware(enabled *atomic.Bool, basePath string, next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(maintenanceRetryAfter.Seconds()))
+	apiPrefix := basePath + "/api/"
+	healthPath := basePath + "/api/healthz"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if enabled.Load() && strings.HasPrefix(r.URL.Path, apiPrefix) && r.URL.Path != healthPath {
+			w.Header().Set("Retry-After", retryAfter)
+			respondWithError(w, r, http.StatusServiceUnavailable, errCodeMaintenance, "maintenance")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// prefixMux registers every pattern under prefix, such as BASE_PATH, so
+// "GET /api/chirps" is served at "GET /chirpy/api/chirps". Registering the
+// full path, rather than stripping the prefix before routing, keeps the
+// mux's redirects pointing inside the prefix.
+type prefixMux struct {
+	*http.ServeMux
+	prefix string
+}
+
+func (m prefixMux) Handle(pattern string, handler http.Handler) {
+	m.ServeMux.Handle(m.withPrefix(pattern), handler)
+}
+
+func (m prefixMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.ServeMux.HandleFunc(m.withPrefix(pattern), handler)
+}
+
+func (m prefixMux) withPrefix(pattern string) string {
+	if method, path, ok := strings.Cut(pattern, " "); ok {
+		return method + " " + m.prefix + path
+	}
+	return m.prefix + pattern
+}
+
+// probeMethods are the methods apiNotFoundHandler tries when deciding
+// whether an unmatched request hit a real route with the wrong method.
+var probeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// apiNotFoundHandler is the catch-all for /api/, answering unknown routes
+// with a JSON 404. Because it matches every method, it also shadows the mux's
+// own 405 handling, so it checks whether the path is routed for some other
+// method and answers 405 with an Allow header if so.
+func apiNotFoundHandler(mux *http.ServeMux, catchAll string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range probeMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := mux.Handler(probe); pattern != "" && pattern != catchAll {
+				allowed = append(allowed, method)
+			}
+		}
+
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			respondWithError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		respondWithError(w, r, http.StatusNotFound, errCodeNotFound, "not found")
+	}
+}
+
+// methodNotAllowedMiddleware rewrites the mux's plain-text 405 responses into
+// the API's JSON error format. The mux has already set an accurate Allow
+// header listing the methods registered for the path.
+func methodNotAllowedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&methodNotAllowedWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+type methodNotAllowedWriter struct {
+	http.ResponseWriter
+	r         *http.Request
+	rewritten bool
+}
+
+func (w *methodNotAllowedWriter) WriteHeader(code int) {
+	if code == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "" {
+		w.rewritten = true
+		w.Header().Del("X-Content-Type-Options")
+		respondWithError(w.ResponseWriter, w.r, code, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *methodNotAllowedWriter) Write(b []byte) (int, error) {
+	if w.rewritten {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *methodNotAllowedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *methodNotAllowedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+
+	"github.com/pressly/goose/v3"
+)
+
+const migrationsDir = "sql/schema"
+
+//go:embed sql/schema/*.sql
+var migrations embed.FS
+
+// runMigrations runs a goose command ("up", "down", "status", ...) against db
+// using the migrations embedded in the binary.
+func runMigrations(ctx context.Context, db *sql.DB, command string) error {
+	goose.SetBaseFS(migrations)
+	if err := goose.SetDialect("postgres"); err != nil {
+		return err
+	}
+	return goose.RunContext(ctx, command, db, migrationsDir)
+}
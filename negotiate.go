@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// marshalBody encodes payload in the format the request prefers, returning
+// the Content-Type to send with it. JSON is the default; XML is only used
+// when Accept ranks application/xml above application/json, so */* and
+// missing headers get JSON.
+func marshalBody(r *http.Request, payload interface{}) (string, []byte, error) {
+	if !prefersXML(r) {
+		body, err := json.Marshal(payload)
+		return "application/json", append(body, '\n'), err
+	}
+
+	body, err := xml.Marshal(xmlPayload(payload))
+	return "application/xml", append([]byte(xml.Header), body...), err
+}
+
+// prefersXML reports whether r's Accept header gives application/xml a
+// higher quality than application/json.
+func prefersXML(r *http.Request) bool {
+	var xmlQ, jsonQ float64
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/xml":
+			xmlQ = max(xmlQ, q)
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return xmlQ > 0 && xmlQ > jsonQ
+}
+
+// xmlPayload adapts the payloads handlers pass to respondWith for
+// encoding/xml, which needs a single root element and can't encode maps.
+func xmlPayload(payload interface{}) interface{} {
+	switch v := reflect.ValueOf(payload); v.Kind() {
+	case reflect.Slice:
+		return xmlList{Items: payload}
+	case reflect.Map:
+		return xmlMap(v)
+	}
+	return payload
+}
+
+// xmlList wraps a slice in a <list> root, each item named by its own type's
+// XML name.
+type xmlList struct {
+	XMLName xml.Name `xml:"list"`
+	Items   interface{}
+}
+
+// xmlMap encodes a map with string keys as a <response> element holding one
+// child per key, in key order.
+type xmlMap reflect.Value
+
+func (m xmlMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	v := reflect.Value(m)
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	slices.Sort(keys)
+
+	start = xml.StartElement{Name: xml.Name{Local: "response"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		value := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())).Interface()
+		if err := e.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// routePatternConsts resolves the constants main.go uses in route patterns.
+var routePatternConsts = map[string]string{
+	"chirpStreamPath": chirpStreamPath,
+	"chirpEventsPath": chirpEventsPath,
+}
+
+// registeredRoutes returns the "METHOD /path" patterns main.go registers
+// on mux. Patterns without a method, such as the static file server and the
+// /api/ catch-all, are left out.
+func registeredRoutes(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parse main.go: %v", err)
+	}
+
+	var routes []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (sel.Sel.Name != "Handle" && sel.Sel.Name != "HandleFunc") {
+			return true
+		}
+		if recv, ok := sel.X.(*ast.Ident); !ok || recv.Name != "mux" {
+			return true
+		}
+
+		pattern := evalPattern(t, call.Args[0])
+		if strings.Contains(pattern, " ") {
+			routes = append(routes, pattern)
+		}
+		return true
+	})
+	return routes
+}
+
+func evalPattern(t *testing.T, expr ast.Expr) string {
+	t.Helper()
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		s, err := strconv.Unquote(e.Value)
+		if err != nil {
+			t.Fatalf("unquote %s: %v", e.Value, err)
+		}
+		return s
+	case *ast.Ident:
+		s, ok := routePatternConsts[e.Name]
+		if !ok {
+			t.Fatalf("route pattern uses unknown identifier %s; add it to routePatternConsts", e.Name)
+		}
+		return s
+	case *ast.BinaryExpr:
+		return evalPattern(t, e.X) + evalPattern(t, e.Y)
+	}
+	t.Fatalf("unsupported route pattern expression %T", expr)
+	return ""
+}
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("unmarshal openapi.json: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want a 3.x version", spec.OpenAPI)
+	}
+
+	routes := registeredRoutes(t)
+	if len(routes) == 0 {
+		t.Fatal("found no routes in main.go")
+	}
+
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		ops, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("%s is registered but %s is missing from openapi.json", route, path)
+			continue
+		}
+		if _, ok := ops[strings.ToLower(method)]; !ok {
+			t.Errorf("%s is registered but openapi.json has no %s operation for %s", route, strings.ToLower(method), path)
+		}
+	}
+}
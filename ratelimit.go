@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a per-key token bucket allowing limit requests per window.
+// Buckets that have been idle for a full window are already refilled, so
+// they're dropped to keep the map from growing without bound.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	capacity  float64
+	perSecond float64
+	window    time.Duration
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		buckets:   make(map[string]*bucket),
+		capacity:  float64(limit),
+		perSecond: float64(limit) / window.Seconds(),
+		window:    window,
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from key's bucket. If none is available it returns
+// false and how long until one will be.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	return rl.allowN(key, 1)
+}
+
+// burst is the most tokens a bucket can hold, and so the largest n that
+// allowN can ever grant.
+func (rl *rateLimiter) burst() int {
+	return int(rl.capacity)
+}
+
+// allowN takes n tokens from key's bucket at once, or none. If n is more
+// than the bucket can ever hold it returns false with a zero wait.
+func (rl *rateLimiter) allowN(key string, n int) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) >= rl.window {
+		for k, b := range rl.buckets {
+			if now.Sub(b.last) >= rl.window {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.capacity, last: now}
+		rl.buckets[key] = b
+	}
+
+	b.tokens = math.Min(rl.capacity, b.tokens+now.Sub(b.last).Seconds()*rl.perSecond)
+	b.last = now
+
+	need := float64(n)
+	if need > rl.capacity {
+		return false, 0
+	}
+	if b.tokens < need {
+		wait := time.Duration((need - b.tokens) / rl.perSecond * float64(time.Second))
+		return false, wait
+	}
+	b.tokens -= need
+	return true, 0
+}
+
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := rl.allow(clientIP(r)); !ok {
+			rateLimited(w, r, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimited answers 429, with a Retry-After header when wait says how long
+// until the request could succeed.
+func rateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	if wait > 0 {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	respondWithError(w, r, http.StatusTooManyRequests, errCodeRateLimited, "Too many requests")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// seedPassword is the password of every seeded user.
+const seedPassword = "chirpy-demo"
+
+// seedTime anchors the seeded timestamps so repeated seeds produce the same
+// rows.
+var seedTime = time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+var seedUsers = []struct {
+	id    uuid.UUID
+	email string
+}{
+	{uuid.MustParse("00000000-0000-4000-8000-000000000001"), "saul@bettercall.com"},
+	{uuid.MustParse("00000000-0000-4000-8000-000000000002"), "walt@breakingbad.com"},
+	{uuid.MustParse("00000000-0000-4000-8000-000000000003"), "jesse@breakingbad.com"},
+}
+
+// seedChirps refer to seedUsers by index.
+var seedChirps = []struct {
+	id   uuid.UUID
+	user int
+	body string
+}{
+	{uuid.MustParse("00000000-0000-4000-8000-000000000101"), 0, "Did you know that they call me Saul Goodman?"},
+	{uuid.MustParse("00000000-0000-4000-8000-000000000102"), 1, "I am the one who knocks!"},
+	{uuid.MustParse("00000000-0000-4000-8000-000000000103"), 2, "Yeah, science!"},
+	{uuid.MustParse("00000000-0000-4000-8000-000000000104"), 0, "S'all good, man."},
+	{uuid.MustParse("00000000-0000-4000-8000-000000000105"), 1, "Say my name."},
+}
+
+// seedData inserts seedUsers and seedChirps in one transaction, unless
+// there are already users, in which case it does nothing.
+func (cfg *apiConfig) seedData(ctx context.Context, db *sql.DB) error {
+	hashed, err := hashPassword(seedPassword, cfg.bcryptCost)
+	if err != nil {
+		return err
+	}
+
+	seeded := false
+	err = withTx(ctx, db, func(tx *sql.Tx) error {
+		qtx := database.New(cfg.logSlowQueries(tx))
+
+		if n, err := qtx.CountUsers(ctx); err != nil || n > 0 {
+			return err
+		}
+
+		for i, u := range seedUsers {
+			createdAt := seedTime.Add(time.Duration(i) * time.Minute)
+			if _, err := qtx.CreateUser(ctx, database.CreateUserParams{
+				ID:             u.id,
+				CreatedAt:      createdAt,
+				UpdatedAt:      createdAt,
+				Email:          u.email,
+				HashedPassword: hashed,
+			}); err != nil {
+				return err
+			}
+		}
+
+		for i, c := range seedChirps {
+			createdAt := seedTime.Add(time.Hour + time.Duration(i)*time.Minute)
+			if _, err := qtx.CreateChirp(ctx, database.CreateChirpParams{
+				ID:        c.id,
+				CreatedAt: createdAt,
+				UpdatedAt: createdAt,
+				Body:      c.body,
+				UserID:    seedUsers[c.user].id,
+			}); err != nil {
+				return err
+			}
+		}
+
+		seeded = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if seeded {
+		slog.InfoContext(ctx, "Seeded sample data",
+			"users", len(seedUsers),
+			"chirps", len(seedChirps),
+			"password", seedPassword,
+		)
+	} else {
+		slog.InfoContext(ctx, "Skipped seeding, database already has users")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/NishanthPrem/go_chirpy/internal/database"
+)
+
+// slowQueryDB wraps a database.DBTX and logs a warning for any query that
+// takes longer than threshold. Arguments are left out of the log since they
+// can hold password hashes and tokens.
+type slowQueryDB struct {
+	db        database.DBTX
+	threshold time.Duration
+}
+
+// logSlowQueries wraps db in a slowQueryDB using cfg.slowQueryThreshold, or
+// returns it unchanged if the threshold is 0.
+func (cfg *apiConfig) logSlowQueries(db database.DBTX) database.DBTX {
+	if cfg.slowQueryThreshold == 0 {
+		return db
+	}
+	return slowQueryDB{db: db, threshold: cfg.slowQueryThreshold}
+}
+
+func (d slowQueryDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer d.observe(ctx, query, time.Now())
+	return d.db.ExecContext(ctx, query, args...)
+}
+
+func (d slowQueryDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return d.db.PrepareContext(ctx, query)
+}
+
+// QueryContext times the query up to its first results; time spent reading
+// the rows afterwards isn't counted.
+func (d slowQueryDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer d.observe(ctx, query, time.Now())
+	return d.db.QueryContext(ctx, query, args...)
+}
+
+func (d slowQueryDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer d.observe(ctx, query, time.Now())
+	return d.db.QueryRowContext(ctx, query, args...)
+}
+
+func (d slowQueryDB) observe(ctx context.Context, query string, start time.Time) {
+	if elapsed := time.Since(start); elapsed > d.threshold {
+		slog.WarnContext(ctx, "Slow query",
+			"query", strings.Join(strings.Fields(query), " "),
+			"duration", elapsed,
+			"threshold", d.threshold,
+		)
+	}
+}
@@ -0,0 +1,100 @@
+-- name: CreateChirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_id, timezone)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: GetChirps :many
+SELECT sqlc.embed(chirps),
+    CASE WHEN sqlc.arg('expand_likes')::boolean
+        THEN (SELECT COUNT(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id)
+        ELSE 0 END::bigint AS like_count,
+    CASE WHEN sqlc.arg('expand_replies')::boolean
+        THEN (SELECT COUNT(*) FROM chirps AS replies WHERE replies.parent_id = chirps.id AND replies.deleted_at IS NULL)
+        ELSE 0 END::bigint AS reply_count,
+    CASE WHEN sqlc.arg('include_author')::boolean
+        THEN (SELECT users.email FROM users WHERE users.id = chirps.user_id)
+        ELSE '' END::text AS author_email
+FROM chirps
+WHERE chirps.deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR chirps.user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('created_before')::timestamp IS NULL OR chirps.created_at < sqlc.narg('created_before'))
+  AND (sqlc.narg('created_after')::timestamp IS NULL OR chirps.created_at > sqlc.narg('created_after'))
+  AND (sqlc.narg('query')::text IS NULL OR chirps.body ILIKE '%' || sqlc.narg('query') || '%')
+  AND (NOT sqlc.arg('top_level_only')::boolean OR chirps.parent_id IS NULL)
+ORDER BY
+    CASE WHEN sqlc.narg('author_id')::uuid IS NOT NULL THEN chirps.is_pinned END DESC,
+    CASE WHEN sqlc.arg('sort_desc')::boolean THEN chirps.created_at END DESC,
+    chirps.created_at ASC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: GetChirp :one
+SELECT sqlc.embed(chirps),
+    (SELECT COUNT(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id) AS like_count,
+    users.email AS author_email
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = $1 AND chirps.deleted_at IS NULL;
+
+-- name: GetChirpsByIDs :many
+SELECT sqlc.embed(chirps),
+    (SELECT COUNT(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id) AS like_count,
+    users.email AS author_email
+FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.id = ANY(sqlc.arg('ids')::uuid[]) AND chirps.deleted_at IS NULL;
+
+-- name: GetChirpsSince :many
+SELECT sqlc.embed(chirps),
+    (SELECT COUNT(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id) AS like_count
+FROM chirps
+WHERE created_at >= $1 AND deleted_at IS NULL
+ORDER BY created_at ASC
+LIMIT $2 OFFSET $3;
+
+-- name: GetChirpReplies :many
+SELECT sqlc.embed(chirps),
+    (SELECT COUNT(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id) AS like_count
+FROM chirps
+WHERE parent_id = $1 AND deleted_at IS NULL
+ORDER BY created_at ASC;
+
+-- name: GetFeed :many
+SELECT sqlc.embed(chirps),
+    (SELECT COUNT(*) FROM chirp_likes WHERE chirp_likes.chirp_id = chirps.id) AS like_count
+FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = $1 AND chirps.deleted_at IS NULL
+ORDER BY chirps.created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: UpdateChirp :one
+UPDATE chirps
+SET body = $1, updated_at = $2
+WHERE id = $3
+RETURNING *;
+
+-- name: CountChirps :one
+SELECT COUNT(*) FROM chirps
+WHERE deleted_at IS NULL
+  AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+  AND (sqlc.narg('created_before')::timestamp IS NULL OR created_at < sqlc.narg('created_before'))
+  AND (sqlc.narg('created_after')::timestamp IS NULL OR created_at > sqlc.narg('created_after'))
+  AND (sqlc.narg('query')::text IS NULL OR body ILIKE '%' || sqlc.narg('query') || '%')
+  AND (NOT sqlc.arg('top_level_only')::boolean OR parent_id IS NULL);
+
+-- name: DeleteChirp :exec
+DELETE FROM chirps
+WHERE id = $1;
+
+-- name: DeleteChirps :exec
+DELETE FROM chirps;
+
+-- name: SetChirpPinned :exec
+UPDATE chirps
+SET is_pinned = $1, updated_at = $2
+WHERE id = $3;
+
+-- name: SoftDeleteChirp :exec
+UPDATE chirps
+SET deleted_at = $1, updated_at = $2, is_pinned = FALSE
+WHERE id = $3;
@@ -0,0 +1,18 @@
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at <= $1;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE user_id = $1 AND key = $2 AND expires_at > $3;
+
+-- name: SaveIdempotencyKey :execrows
+INSERT INTO idempotency_keys (user_id, key, created_at, expires_at, status_code, response_body, content_type)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (user_id, key) DO UPDATE
+SET created_at = EXCLUDED.created_at,
+    expires_at = EXCLUDED.expires_at,
+    status_code = EXCLUDED.status_code,
+    response_body = EXCLUDED.response_body,
+    content_type = EXCLUDED.content_type
+WHERE idempotency_keys.expires_at <= EXCLUDED.created_at;
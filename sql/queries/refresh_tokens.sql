@@ -0,0 +1,17 @@
+-- name: CreateRefreshToken :exec
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetUserFromRefreshToken :one
+SELECT user_id FROM refresh_tokens
+WHERE token = $1 AND revoked_at IS NULL AND expires_at > $2;
+
+-- name: RevokeRefreshToken :exec
+UPDATE refresh_tokens
+SET revoked_at = $1, updated_at = $2
+WHERE token = $3;
+
+-- name: RevokeUserRefreshTokens :execrows
+UPDATE refresh_tokens
+SET revoked_at = $1, updated_at = $2
+WHERE user_id = $3 AND revoked_at IS NULL;
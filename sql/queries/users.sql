@@ -1,7 +1,56 @@
 -- name: CreateUser :one
-INSERT INTO users (email)
-VALUES ("nishanth@gmail.com")
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
--- name: DeleteAllUsers :exec
+-- name: GetUser :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: GetUsers :many
+SELECT * FROM users
+ORDER BY created_at ASC;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users
+WHERE LOWER(email) = LOWER(sqlc.arg('email'));
+
+-- name: UpdateUser :one
+UPDATE users
+SET email = $1, hashed_password = $2, updated_at = $3
+WHERE id = $4
+RETURNING *;
+
+-- name: PatchUser :one
+UPDATE users
+SET email = COALESCE(sqlc.narg('email'), email),
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    updated_at = sqlc.arg('updated_at')
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: UpgradeUserToChirpyRed :execrows
+UPDATE users
+SET is_chirpy_red = TRUE, updated_at = $1
+WHERE id = $2;
+
+-- name: VerifyUserEmail :one
+UPDATE users
+SET email_verified = TRUE, updated_at = $1
+WHERE id = $2
+RETURNING *;
+
+-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1;
+
+-- name: DeleteUsers :exec
 DELETE FROM users;
+
+-- name: CountUsers :one
+SELECT COUNT(*) FROM users;
+
+-- name: LockUser :exec
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE;
@@ -0,0 +1,11 @@
+-- name: CreateVerificationToken :exec
+INSERT INTO verification_tokens (token_hash, created_at, user_id, expires_at)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetUserFromVerificationToken :one
+SELECT user_id FROM verification_tokens
+WHERE token_hash = $1 AND expires_at > $2;
+
+-- name: DeleteVerificationTokens :exec
+DELETE FROM verification_tokens
+WHERE user_id = $1;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Emails are stored lowercased from now on. Where existing addresses differ
+-- only by case, the oldest account keeps the address and the others get a
+-- unique placeholder so the index can be built; nothing is deleted, and
+-- those accounts can be fixed by hand
+UPDATE users SET email = 'duplicate-' || id || '+' || LOWER(email)
+WHERE EXISTS (
+    SELECT 1 FROM users older
+    WHERE LOWER(older.email) = LOWER(users.email)
+      AND (older.created_at, older.id) < (users.created_at, users.id)
+);
+UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email);
+
+CREATE UNIQUE INDEX users_email_lower_idx ON users (LOWER(email));
+
+-- +goose Down
+DROP INDEX users_email_lower_idx;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN timezone TEXT;
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN timezone;
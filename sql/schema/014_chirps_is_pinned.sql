@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN is_pinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Each user can pin at most one chirp
+CREATE UNIQUE INDEX chirps_user_id_pinned_idx ON chirps (user_id) WHERE is_pinned;
+
+-- +goose Down
+DROP INDEX chirps_user_id_pinned_idx;
+ALTER TABLE chirps DROP COLUMN is_pinned;
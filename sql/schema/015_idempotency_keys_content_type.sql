@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE idempotency_keys ADD COLUMN content_type TEXT NOT NULL DEFAULT 'application/json';
+
+-- Lets expired keys be purged without a full scan
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+-- +goose Down
+DROP INDEX idempotency_keys_expires_at_idx;
+ALTER TABLE idempotency_keys DROP COLUMN content_type;
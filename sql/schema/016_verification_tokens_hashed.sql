@@ -0,0 +1,10 @@
+-- +goose Up
+-- Hash outstanding tokens in place so they keep working, matching the
+-- hex SHA-256 that hashToken computes
+UPDATE verification_tokens SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+ALTER TABLE verification_tokens RENAME COLUMN token TO token_hash;
+
+-- +goose Down
+-- Hashes can't be reversed, so outstanding tokens are dropped
+DELETE FROM verification_tokens;
+ALTER TABLE verification_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	chirpStreamPath = "/api/chirps/stream"
+	chirpEventsPath = "/api/chirps/events"
+
+	streamWriteTimeout = 10 * time.Second
+	streamPongTimeout  = 60 * time.Second
+	streamPingInterval = streamPongTimeout * 9 / 10
+
+	eventsKeepAliveInterval = 15 * time.Second
+)
+
+// streamPaths are long-lived connections that must not be subject to the
+// per-request timeout.
+var streamPaths = []string{chirpStreamPath, chirpEventsPath}
+
+var upgrader = websocket.Upgrader{
+	// Chirps are public, the same as GET /api/chirps, so any origin may
+	// subscribe
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// chirpStreamHandler upgrades the request to a WebSocket and pushes every
+// newly created chirp to it as JSON. At most maxConns streams are open at
+// once; further clients get a 503.
+func (cfg *apiConfig) chirpStreamHandler(maxConns int) http.HandlerFunc {
+	slots := make(chan struct{}, maxConns)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		default:
+			respondWithError(w, r, http.StatusServiceUnavailable, errCodeUnavailable, "Too many stream connections")
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already written an error response
+			slog.WarnContext(r.Context(), "WebSocket upgrade failed", "error", err)
+			return
+		}
+		defer conn.Close()
+
+		chirps, unsubscribe := cfg.broker.subscribe()
+		defer unsubscribe()
+
+		// The client never sends anything we need, but reading is how
+		// close frames and dropped connections are noticed
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			conn.SetReadLimit(512)
+			conn.SetReadDeadline(time.Now().Add(streamPongTimeout))
+			conn.SetPongHandler(func(string) error {
+				return conn.SetReadDeadline(time.Now().Add(streamPongTimeout))
+			})
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(streamPingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case chirp, ok := <-chirps:
+				conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if !ok {
+					// The server is shutting down
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+					return
+				}
+				if err := conn.WriteJSON(chirp); err != nil {
+					return
+				}
+			case <-ping.C:
+				conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+}
+
+// chirpEventsHandler streams every newly created chirp as a server-sent
+// event. At most maxConns streams are open at once; further clients get a
+// 503.
+func (cfg *apiConfig) chirpEventsHandler(maxConns int) http.HandlerFunc {
+	slots := make(chan struct{}, maxConns)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		default:
+			respondWithError(w, r, http.StatusServiceUnavailable, errCodeUnavailable, "Too many stream connections")
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// The server's WriteTimeout would otherwise cut the stream off
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			slog.ErrorContext(r.Context(), "Error clearing write deadline", "error", err)
+			respondWithError(w, r, http.StatusInternalServerError, errCodeInternal, "Streaming unsupported")
+			return
+		}
+
+		chirps, unsubscribe := cfg.broker.subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(eventsKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case chirp, ok := <-chirps:
+				if !ok {
+					// The server is shutting down
+					return
+				}
+				data, err := json.Marshal(chirp)
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Error encoding chirp event", "error", err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %s\nevent: chirp\ndata: %s\n\n", chirp.ID, data); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// stubQueryFunc answers one query against a stub database with the columns
+// and rows it should return.
+type stubQueryFunc func(query string, args []driver.NamedValue) (columns []string, rows [][]driver.Value, err error)
+
+var (
+	stubDrivers  sync.Map
+	stubNextID   atomic.Int64
+	registerStub sync.Once
+)
+
+// newStubDB returns a *sql.DB whose queries are all answered by fn, for
+// exercising handlers through database.New without a real Postgres.
+func newStubDB(t *testing.T, fn stubQueryFunc) *sql.DB {
+	t.Helper()
+	registerStub.Do(func() { sql.Register("stub", stubDriver{}) })
+
+	name := strconv.FormatInt(stubNextID.Add(1), 10)
+	stubDrivers.Store(name, fn)
+	db, err := sql.Open("stub", name)
+	if err != nil {
+		t.Fatalf("open stub db: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		stubDrivers.Delete(name)
+	})
+	return db
+}
+
+type stubDriver struct{}
+
+func (stubDriver) Open(name string) (driver.Conn, error) {
+	fn, ok := stubDrivers.Load(name)
+	if !ok {
+		return nil, errors.New("unknown stub database " + name)
+	}
+	return &stubConn{fn: fn.(stubQueryFunc)}, nil
+}
+
+type stubConn struct {
+	fn stubQueryFunc
+}
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("stub: Prepare not supported")
+}
+
+func (c *stubConn) Close() error { return nil }
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("stub: transactions not supported")
+}
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := c.fn(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &stubRows{columns: columns, rows: rows}, nil
+}
+
+func (c *stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, _, err := c.fn(query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+
+func (r *stubRows) Close() error { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}